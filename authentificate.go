@@ -2,10 +2,12 @@ package middlewares
 
 import (
 	"context"
+	"crypto"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -15,7 +17,13 @@ import (
 type uidstr int
 
 const (
-	AuthUID uidstr = iota
+	AuthUID        uidstr = iota
+	JOSEHeader            // *JWEHeader of request decripted by DecriptMiddleware.
+	DecriptKeyID          // Key ID of request decripted by DecriptMiddleware in X25519 mode.
+	CompressStats         // *CompressionStats of response, filled by compression middleware.
+	SignatureKeyID        // Key ID of request signature checked by NewSignatureMiddleware.
+	RequestBody           // []byte of request body processed by NewBodyMiddleware.
+	HashUpload            // *UploadChunk of chunked upload checked by NewHashCheckMiddleware.
 )
 
 type authJWTStruct struct {
@@ -26,16 +34,21 @@ type authJWTStruct struct {
 	UID       int
 }
 
-// CreateToken creates JWT token.
-func CreateToken(key []byte, liveTime, uid int, ua, ip string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, authJWTStruct{
+// newAuthClaims internal function.
+func newAuthClaims(liveTime, uid int, ua, ip string) authJWTStruct {
+	return authJWTStruct{
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(liveTime) * time.Second)),
 		},
 		UserAgent: ua,
 		IP:        ip,
 		UID:       uid,
-	})
+	}
+}
+
+// CreateToken creates JWT token.
+func CreateToken(key []byte, liveTime, uid int, ua, ip string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, newAuthClaims(liveTime, uid, ua, ip))
 	tokenString, err := token.SignedString(key)
 	if err != nil {
 		return "", fmt.Errorf("sign user token error: %w", err)
@@ -43,19 +56,53 @@ func CreateToken(key []byte, liveTime, uid int, ua, ip string) (string, error) {
 	return tokenString, nil
 }
 
+// CreateTokenWithSigner creates JWT token signed by signer.
+// Algorithm is RS256, ES256 (ES384, ES512) or EdDSA according to the signer public key.
+func CreateTokenWithSigner(signer crypto.Signer, liveTime, uid int, ua, ip string) (string, error) {
+	method, err := newSignerMethod(signer.Public())
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(method, newAuthClaims(liveTime, uid, ua, ip))
+	tokenString, err := token.SignedString(signer)
+	if err != nil {
+		return "", fmt.Errorf("sign user token error: %w", err)
+	}
+	return tokenString, nil
+}
+
 // checkAuthToken internal function for check JWT token.
 func checkAuthToken(r *http.Request, key []byte) (int, error) {
-	token := r.Header.Get(authHeader)
-	if token == "" {
-		return 0, errors.New("token is empty")
-	}
-	claims := &authJWTStruct{}
-	info, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+	return parseAuthToken(r, func(t *jwt.Token) (interface{}, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
 		}
 		return key, nil
 	})
+}
+
+// checkSignerToken internal function for check JWT token signed by signer.
+func checkSignerToken(r *http.Request, public crypto.PublicKey) (int, error) {
+	method, err := newSignerMethod(public)
+	if err != nil {
+		return 0, err
+	}
+	return parseAuthToken(r, func(t *jwt.Token) (interface{}, error) {
+		if t.Method.Alg() != method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return public, nil
+	})
+}
+
+// parseAuthToken internal function for parse JWT token and check user data.
+func parseAuthToken(r *http.Request, keyFunc jwt.Keyfunc) (int, error) {
+	token := r.Header.Get(authHeader)
+	if token == "" {
+		return 0, errors.New("token is empty")
+	}
+	claims := &authJWTStruct{}
+	info, err := jwt.ParseWithClaims(token, claims, keyFunc)
 	if err != nil {
 		return 0, fmt.Errorf("auth token parse error: %w", err)
 	}
@@ -74,9 +121,66 @@ func checkAuthToken(r *http.Request, key []byte) (int, error) {
 
 // AuthMiddleware checks JWT token from request header "Authorization".
 func AuthMiddleware(logger *zap.SugaredLogger, redirectURL string, key []byte) func(h http.Handler) http.Handler {
+	return AuthMiddlewareFunc(logger, redirectURL, func() []byte { return key })
+}
+
+// NewAuthMiddleware checks JWT token signed by the default signer of provider
+// (see CreateTokenWithSigner). The private key may live outside the process.
+// Public key of the signer is requested once and cached, so the middleware must be
+// recreated when the default key of provider is replaced.
+func NewAuthMiddleware(
+	logger *zap.SugaredLogger,
+	redirectURL string,
+	provider KeyProvider,
+) func(h http.Handler) http.Handler {
+	var (
+		public crypto.PublicKey
+		mutex  sync.Mutex
+	)
+	publicKey := func() (crypto.PublicKey, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if public != nil {
+			return public, nil
+		}
+		signer, err := provider.Signer("")
+		if err != nil {
+			return nil, fmt.Errorf("get signer error: %w", err)
+		}
+		public = signer.Public()
+		return public, nil
+	}
+	return authMiddleware(logger, redirectURL, func(r *http.Request) (int, error) {
+		key, err := publicKey()
+		if err != nil {
+			return 0, err
+		}
+		return checkSignerToken(r, key)
+	})
+}
+
+// AuthMiddlewareFunc checks JWT token by the key returned from keyFunc.
+// The keyFunc is called for every request, so the key may be replaced at runtime
+// (see keys.Watcher).
+func AuthMiddlewareFunc(
+	logger *zap.SugaredLogger,
+	redirectURL string,
+	keyFunc func() []byte,
+) func(h http.Handler) http.Handler {
+	return authMiddleware(logger, redirectURL, func(r *http.Request) (int, error) {
+		return checkAuthToken(r, keyFunc())
+	})
+}
+
+// authMiddleware internal function.
+func authMiddleware(
+	logger *zap.SugaredLogger,
+	redirectURL string,
+	check func(r *http.Request) (int, error),
+) func(h http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
-			uid, err := checkAuthToken(r, key)
+			uid, err := check(r)
 			if err != nil {
 				http.Redirect(w, r, redirectURL, http.StatusUnauthorized)
 				logger.Warnf("%s authorization token error: %w", r.URL.Path, err)
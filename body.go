@@ -0,0 +1,315 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+const readStageName = "read"
+
+// ErrBodyTooLarge is returned when request body or result of body stage is greater than limit.
+var ErrBodyTooLarge = errors.New("request body is too large")
+
+// BodyStage is a transformation or check of request body in NewBodyMiddleware.
+type BodyStage struct {
+	// Apply returns body after the stage and request with updated context. Result must not
+	// be greater than limit.
+	Apply func(r *http.Request, body []byte, limit int64) ([]byte, *http.Request, error)
+	// Status returns response status for stage error, 400 is used if Status is nil.
+	Status func(err error) int
+	// Name of the stage for error reports.
+	Name string
+}
+
+// BodyStageError is error of body stage.
+type BodyStageError struct {
+	Err    error  // Error of stage.
+	Stage  string // Name of failed stage.
+	Status int    // Response status.
+}
+
+// Error returns error message.
+func (e *BodyStageError) Error() string {
+	return fmt.Sprintf("body stage '%s' error: %v", e.Stage, e.Err)
+}
+
+// Unwrap returns stage error.
+func (e *BodyStageError) Unwrap() error {
+	return e.Err
+}
+
+// Internal types.
+type (
+	// NewBodyMiddleware settings.
+	bodyConfig struct {
+		onError func(w http.ResponseWriter, r *http.Request, err *BodyStageError)
+		limit   int64
+	}
+	// Request body of data, which is known to the middlewares.
+	bodyReader struct {
+		*bytes.Reader
+		data []byte
+	}
+)
+
+// BodyOption sets NewBodyMiddleware settings.
+type BodyOption func(*bodyConfig)
+
+// WithBodyLimit sets max size of request body and results of all stages (32 MiB by default).
+// Requests with larger body are rejected with 413 status. Zero or negative limit means the default.
+func WithBodyLimit(limit int64) BodyOption {
+	return func(c *bodyConfig) {
+		if limit <= 0 {
+			limit = defaultMaxDecompressedSize
+		}
+		c.limit = limit
+	}
+}
+
+// WithBodyErrorHandler sets function which writes response of rejected request.
+// By default status of the failed stage is written.
+func WithBodyErrorHandler(fn func(w http.ResponseWriter, r *http.Request, err *BodyStageError)) BodyOption {
+	return func(c *bodyConfig) {
+		c.onError = fn
+	}
+}
+
+// DigestStage checks Content-Digest and Repr-Digest of request body as NewDigestMiddleware.
+// Digests are calculated for data on the wire, so the stage must precede DecompressStage.
+func DigestStage(opts ...DigestOption) BodyStage {
+	cfg := digestConfig{algorithms: []string{DigestSHA256}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return BodyStage{
+		Name: "digest",
+		Apply: func(r *http.Request, body []byte, _ int64) ([]byte, *http.Request, error) {
+			return body, r, cfg.check(r, body)
+		},
+	}
+}
+
+// DecompressStage decodes request body by Content-Encoding header with encoders registered by
+// RegisterEncoder. Limits of WithMaxDecompressedSize and WithMaxRatio options are used, and
+// decompressed size is not greater than body limit. Content-Encoding header is removed,
+// so NewCompressMiddleware does not decode the body again.
+func DecompressStage(opts ...CompressOption) BodyStage {
+	cfg := newCompressConfig(opts...)
+	return BodyStage{
+		Name:   "decompress",
+		Status: readErrorStatus,
+		Apply: func(r *http.Request, body []byte, limit int64) ([]byte, *http.Request, error) {
+			header := r.Header.Get(contentEncoding)
+			if header == "" {
+				return body, r, nil
+			}
+			stageCfg := *cfg
+			if stageCfg.maxSize <= 0 || stageCfg.maxSize > limit {
+				stageCfg.maxSize = limit
+			}
+			reader, err := newBodyDecompressor(io.NopCloser(bytes.NewReader(body)), header, &stageCfg)
+			if err != nil {
+				return nil, r, err
+			}
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				return nil, r, fmt.Errorf("decompress error: %w", err)
+			}
+			r.Header.Del(contentEncoding)
+			r.Header.Del(contentLength)
+			return data, r, nil
+		},
+	}
+}
+
+// HashStage checks hash summ of request body as NewHashCheckMiddleware with the same options.
+// Response hash summ is not sent, so NewHashCheckMiddleware must be used if clients verify it.
+func HashStage(hashKey []byte, opts ...HashOption) BodyStage {
+	var cfg hashConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.keys == nil && len(hashKey) > 0 {
+		cfg.keys = func(string) ([]byte, error) {
+			return hashKey, nil
+		}
+	}
+	return BodyStage{
+		Name:   "hash",
+		Status: cfg.errorStatus,
+		Apply: func(r *http.Request, body []byte, _ int64) ([]byte, *http.Request, error) {
+			if cfg.keys == nil || !cfg.covers(r.Method) {
+				return body, r, nil
+			}
+			kid := r.Header.Get(hashKeyIDName)
+			key, err := cfg.keys(kid)
+			if err != nil || len(key) == 0 {
+				return nil, r, fmt.Errorf("%w: '%s'", ErrHashKey, kid)
+			}
+			if err = cfg.check(r, body, key); err != nil || !cfg.isMultipart(r) {
+				return body, r, err
+			}
+			return body, r, cfg.checkParts(r, bytes.NewReader(body), key)
+		},
+	}
+}
+
+// DecriptStage decripts request body as NewDecriptMiddleware with the same options.
+// Inflated JWE payload is limited by the less of WithDecriptLimits size and body limit.
+func DecriptStage(opts ...DecriptOption) BodyStage {
+	cfg := newDecriptConfig(opts...)
+	return BodyStage{
+		Name:   "decript",
+		Status: readErrorStatus,
+		Apply: func(r *http.Request, body []byte, limit int64) ([]byte, *http.Request, error) {
+			if r.Method != http.MethodPost && r.Method != http.MethodPut {
+				return body, r, nil
+			}
+			decript := cfg.decripter(r)
+			if decript == nil {
+				return body, r, nil
+			}
+			if cfg.maxSize > 0 && cfg.maxSize < limit {
+				limit = cfg.maxSize
+			}
+			return decript(r, body, limit)
+		},
+	}
+}
+
+// NewBodyMiddleware reads request body once and applies stages in the declared order, e.g.
+// DigestStage, DecompressStage, HashStage, DecriptStage. Body and results of stages are limited by
+// WithBodyLimit. Result is set as request body and RequestBody context value ([]byte), so handlers
+// and the next middlewares do not copy it again. Requests are rejected with status of the failed stage.
+//
+// GzipMiddleware (NewCompressMiddleware), NewDecriptMiddleware, NewHashCheckMiddleware and
+// NewDigestMiddleware placed after NewBodyMiddleware use RequestBody context value instead of
+// reading the body again, while request body is not replaced by other middleware. Decripted
+// and decompressed bodies are set as the context value for the next middlewares.
+func NewBodyMiddleware(
+	logger *zap.SugaredLogger,
+	stages []BodyStage,
+	opts ...BodyOption,
+) func(h http.Handler) http.Handler {
+	cfg := bodyConfig{limit: defaultMaxDecompressedSize}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			r, err := cfg.apply(r, stages)
+			if err != nil {
+				logger.Warnf(err.Error())
+				if cfg.onError != nil {
+					cfg.onError(w, r, err)
+				} else {
+					w.WriteHeader(err.Status)
+				}
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// apply reads body and applies stages. Returns request with result body.
+func (c *bodyConfig) apply(r *http.Request, stages []BodyStage) (*http.Request, *BodyStageError) {
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		data, err := io.ReadAll(io.LimitReader(r.Body, c.limit+1))
+		if err != nil {
+			return r, &BodyStageError{Stage: readStageName, Status: readErrorStatus(err), Err: getError(ReadBodyError, err)}
+		}
+		if err = r.Body.Close(); err != nil {
+			return r, &BodyStageError{Stage: readStageName, Status: http.StatusBadRequest, Err: getError(CloseBodyError, err)}
+		}
+		body = data
+	}
+	if int64(len(body)) > c.limit {
+		return r, &BodyStageError{Stage: readStageName, Status: http.StatusRequestEntityTooLarge, Err: ErrBodyTooLarge}
+	}
+	for _, stage := range stages {
+		data, req, err := stage.Apply(r, body, c.limit)
+		if err == nil && int64(len(data)) > c.limit {
+			err = ErrBodyTooLarge
+		}
+		if err != nil {
+			return r, &BodyStageError{Stage: stage.Name, Status: stageStatus(stage, err), Err: err}
+		}
+		body, r = data, req
+	}
+	r = r.WithContext(context.WithValue(r.Context(), RequestBody, body))
+	setRequestBody(r, body)
+	return r, nil
+}
+
+// Close does nothing.
+func (b *bodyReader) Close() error {
+	return nil
+}
+
+// requestBody returns RequestBody context value set by NewBodyMiddleware, if request body
+// is not replaced or read since. Middlewares use it instead of copying the body again.
+func requestBody(r *http.Request) ([]byte, bool) {
+	data, ok := r.Context().Value(RequestBody).([]byte)
+	if !ok {
+		return nil, false
+	}
+	body, ok := r.Body.(*bodyReader)
+	if !ok || body.Len() != len(data) || len(body.data) != len(data) {
+		return nil, false
+	}
+	if len(data) > 0 && &body.data[0] != &data[0] {
+		return nil, false
+	}
+	return data, true
+}
+
+// readRequestBody returns request body with size limit, zero limit disables it.
+// RequestBody context value is used if it is actual. Returns ErrBodyTooLarge if body exceeds the limit.
+func readRequestBody(r *http.Request, limit int64) ([]byte, error) {
+	data, ok := requestBody(r)
+	if !ok {
+		reader := r.Body
+		if limit > 0 {
+			reader = io.NopCloser(io.LimitReader(r.Body, limit+1))
+		}
+		var err error
+		if data, err = io.ReadAll(reader); err != nil {
+			return nil, err //nolint:wrapcheck //<-wrapped by caller
+		}
+	}
+	if limit > 0 && int64(len(data)) > limit {
+		return nil, ErrBodyTooLarge
+	}
+	return data, nil
+}
+
+// setBody sets data as request body. RequestBody context value is updated if it is set,
+// so the next middlewares use data without copying.
+func setBody(r *http.Request, data []byte) *http.Request {
+	if _, ok := r.Context().Value(RequestBody).([]byte); ok {
+		r = r.WithContext(context.WithValue(r.Context(), RequestBody, data))
+	}
+	setRequestBody(r, data)
+	return r
+}
+
+// stageStatus returns response status for stage error.
+func stageStatus(stage BodyStage, err error) int {
+	switch {
+	case errors.Is(err, ErrBodyTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case stage.Status != nil:
+		return stage.Status(err)
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,322 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestNewBodyMiddleware(t *testing.T) {
+	hashKey := []byte("key")
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	enc, err := NewX25519Encryptor("k1", key.PublicKey())
+	assert.NoError(t, err)
+	data := strings.Repeat(`{"id":"metric","value":1}`, 100)
+	msg, err := enc.Encrypt([]byte(data))
+	assert.NoError(t, err)
+	mac := hmac.New(sha256.New, hashKey)
+	mac.Write(msg) //nolint:errcheck //<-test
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, err = zw.Write(msg)
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	digest, err := ContentDigest(compressed.Bytes())
+	assert.NoError(t, err)
+	request := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(compressed.Bytes()))
+		r.Header.Set(contentType, enc.ContentType())
+		r.Header.Set(contentEncoding, gzipString)
+		r.Header.Set(contentDigest, digest)
+		r.Header.Set(hashVarName, hex.EncodeToString(mac.Sum(nil)))
+		return r
+	}
+	stages := []BodyStage{
+		DigestStage(WithRequiredDigest()),
+		DecompressStage(),
+		HashStage(hashKey, WithStrictHash()),
+		DecriptStage(WithX25519(testKeyLookup(map[string]crypto.PrivateKey{"k1": key}))),
+	}
+
+	tests := []struct {
+		name    string
+		opts    []BodyOption
+		change  func(r *http.Request)
+		status  int
+		stage   string
+		wantErr error
+	}{
+		{name: "All stages", status: http.StatusOK},
+		{
+			name:    "Digest",
+			change:  func(r *http.Request) { r.Header.Del(contentDigest) },
+			status:  http.StatusBadRequest,
+			stage:   "digest",
+			wantErr: ErrDigestMissing,
+		},
+		{
+			name: "Decompress",
+			opts: []BodyOption{WithBodyLimit(64 << 10)},
+			change: func(r *http.Request) {
+				var buf bytes.Buffer
+				zw := gzip.NewWriter(&buf)
+				_, err := zw.Write(make([]byte, 1<<20))
+				assert.NoError(t, err)
+				assert.NoError(t, zw.Close())
+				value, err := ContentDigest(buf.Bytes())
+				assert.NoError(t, err)
+				r.Header.Set(contentDigest, value)
+				r.Body = io.NopCloser(&buf)
+			},
+			status: http.StatusRequestEntityTooLarge,
+			stage:  "decompress",
+		},
+		{
+			name:    "Hash",
+			change:  func(r *http.Request) { r.Header.Set(hashVarName, strings.Repeat("00", 32)) },
+			status:  http.StatusForbidden,
+			stage:   "hash",
+			wantErr: ErrHashMismatch,
+		},
+		{
+			name:    "Not encripted type",
+			change:  func(r *http.Request) { r.Header.Set(contentType, "application/x25519+bad") },
+			status:  http.StatusUnsupportedMediaType,
+			stage:   "decript",
+			wantErr: ErrNotEncripted,
+		},
+		{name: "Read", opts: []BodyOption{WithBodyLimit(10)}, status: http.StatusRequestEntityTooLarge, stage: "read", wantErr: ErrBodyTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stageErr *BodyStageError
+			opts := append([]BodyOption{WithBodyErrorHandler(func(w http.ResponseWriter, r *http.Request, err *BodyStageError) {
+				stageErr = err
+				w.WriteHeader(err.Status)
+			})}, tt.opts...)
+			var body []byte
+			var ctxBody any
+			handler := NewBodyMiddleware(zap.NewNop().Sugar(), stages, opts...)(
+				// Compression middleware must not decode the body again.
+				GzipMiddleware(zap.NewNop().Sugar())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					ctxBody = r.Context().Value(RequestBody)
+					body, err = io.ReadAll(r.Body)
+					assert.NoError(t, err)
+				})))
+			r := request()
+			if tt.change != nil {
+				tt.change(r)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code)
+			if tt.stage == "" {
+				assert.Nil(t, stageErr)
+				if tt.name == "All stages" {
+					assert.Equal(t, data, string(body))
+					assert.Equal(t, []byte(data), ctxBody)
+				}
+				return
+			}
+			if assert.NotNil(t, stageErr) {
+				assert.Equal(t, tt.stage, stageErr.Stage)
+				if tt.wantErr != nil {
+					assert.ErrorIs(t, stageErr, tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestNewBodyMiddleware_contextBody(t *testing.T) {
+	hashKey := []byte("key")
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	enc, err := NewX25519Encryptor("k1", key.PublicKey())
+	assert.NoError(t, err)
+	data := strings.Repeat(`{"id":"metric","value":1}`, 100)
+	msg, err := enc.Encrypt([]byte(data))
+	assert.NoError(t, err)
+	mac := hmac.New(sha256.New, hashKey)
+	mac.Write(msg) //nolint:errcheck //<-test
+	compressed := gzipData(t, msg)
+	digest, err := ContentDigest(compressed)
+	assert.NoError(t, err)
+
+	// probe saves address of RequestBody context value.
+	pointers := make(map[string]*byte)
+	probe := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, ok := requestBody(r)
+				if assert.True(t, ok, "body is not reused after %s", name) && len(body) > 0 {
+					pointers[name] = &body[0]
+				}
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	logger := zap.NewNop().Sugar()
+	var got []byte
+	handler := NewBodyMiddleware(logger, nil)(probe("body")(
+		NewDigestMiddleware(logger, WithRequiredDigest())(probe("digest")(
+			GzipMiddleware(logger)(probe("gzip")(
+				NewHashCheckMiddleware(hashKey, logger, WithStrictHash())(probe("hash")(
+					NewDecriptMiddleware(logger, WithX25519(testKeyLookup(map[string]crypto.PrivateKey{"k1": key})))(probe("decript")(
+						http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+							got, err = io.ReadAll(r.Body)
+							assert.NoError(t, err)
+							assert.Equal(t, got, r.Context().Value(RequestBody))
+						})))))))))))
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(compressed))
+	r.Header.Set(contentType, enc.ContentType())
+	r.Header.Set(contentEncoding, gzipString)
+	r.Header.Set(contentDigest, digest)
+	r.Header.Set(hashVarName, hex.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, data, string(got))
+	assert.Same(t, pointers["body"], pointers["digest"], "body is copied by digest middleware")
+	assert.Same(t, pointers["gzip"], pointers["hash"], "body is copied by hash middleware")
+	assert.NotSame(t, pointers["hash"], pointers["decript"], "decripted body is not set")
+
+	called := false
+	handler = NewBodyMiddleware(logger, nil)(NewCompressMiddleware(logger, WithMaxDecompressedSize(1024))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		})))
+	r = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipData(t, make([]byte, 4096))))
+	r.Header.Set(contentEncoding, gzipString)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.False(t, called, "handler got body over limit")
+}
+
+func TestNewBodyMiddleware_order(t *testing.T) {
+	var order []string
+	stage := func(name string) BodyStage {
+		return BodyStage{
+			Name: name,
+			Apply: func(r *http.Request, body []byte, _ int64) ([]byte, *http.Request, error) {
+				order = append(order, name)
+				if name == "fail" {
+					return nil, r, errors.New("stage error")
+				}
+				return append(body, name...), r, nil
+			},
+			Status: func(error) int { return http.StatusTeapot },
+		}
+	}
+	var body []byte
+	handler := NewBodyMiddleware(zap.NewNop().Sugar(), []BodyStage{stage("a"), stage("b")})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var err error
+			body, err = io.ReadAll(r.Body)
+			assert.NoError(t, err)
+		}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(">")))
+	assert.Equal(t, ">ab", string(body))
+	assert.Equal(t, []string{"a", "b"}, order)
+
+	order = nil
+	handler = NewBodyMiddleware(zap.NewNop().Sugar(), []BodyStage{stage("fail"), stage("b")})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("handler is called")
+		}))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(">")))
+	assert.Equal(t, http.StatusTeapot, w.Code)
+	assert.Equal(t, []string{"fail"}, order)
+}
+
+func TestWithBodyLimit(t *testing.T) {
+	tests := []struct {
+		name   string
+		limit  int64
+		body   string
+		status int
+	}{
+		{name: "Zero limit", limit: 0, body: strings.Repeat("data", 1000), status: http.StatusOK},
+		{name: "Negative limit", limit: -1, body: "data", status: http.StatusOK},
+		{name: "Under limit", limit: 4, body: "data", status: http.StatusOK},
+		{name: "Over limit", limit: 4, body: "data;", status: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body []byte
+			handler := NewBodyMiddleware(zap.NewNop().Sugar(), nil, WithBodyLimit(tt.limit))(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var err error
+					body, err = io.ReadAll(r.Body)
+					assert.NoError(t, err)
+				}))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, tt.body, string(body))
+			}
+		})
+	}
+}
+
+func TestDecriptStage_limit(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	lookup := testKeyLookup(map[string]crypto.PrivateKey{"k1": key})
+	header := map[string]any{"alg": jweAlgRSAOAEP256, "enc": jweEncA256GCM, "kid": "k1", "zip": jweZipDeflate}
+	msg := encriptJWE(t, header, &key.PublicKey, make([]byte, 1<<20))
+	tests := []struct {
+		name   string
+		limit  int64
+		opts   []DecriptOption
+		status int
+	}{
+		{name: "Body limit", limit: 64 << 10, status: http.StatusRequestEntityTooLarge},
+		{name: "Decript limit", limit: 2 << 20, opts: []DecriptOption{WithDecriptLimits(64<<10, 0)}, status: http.StatusRequestEntityTooLarge},
+		{name: "Under limits", limit: 2 << 20, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stageErr *BodyStageError
+			stage := DecriptStage(append([]DecriptOption{WithJWE(lookup)}, tt.opts...)...)
+			handler := NewBodyMiddleware(zap.NewNop().Sugar(), []BodyStage{stage},
+				WithBodyLimit(tt.limit),
+				WithBodyErrorHandler(func(w http.ResponseWriter, r *http.Request, err *BodyStageError) {
+					stageErr = err
+					w.WriteHeader(err.Status)
+				}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(msg))
+			r.Header.Set(contentType, applicationJOSE)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusOK {
+				return
+			}
+			var limitErr *DecompressLimitError
+			if assert.ErrorAs(t, stageErr, &limitErr, "payload is inflated over limit") {
+				assert.Equal(t, int64(64<<10), limitErr.Limit)
+			}
+		})
+	}
+}
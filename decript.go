@@ -1,12 +1,12 @@
 package middlewares
 
 import (
-	"bytes"
+	"crypto"
+	"crypto/rand"
 	"crypto/rsa"
-	"crypto/sha256"
 	"errors"
 	"fmt"
-	"io"
+	"mime"
 	"net/http"
 
 	"go.uber.org/zap"
@@ -45,16 +45,143 @@ func getError(t ErrorType, err error) error {
 	}
 }
 
+// ErrNotEncripted is returned when request body has type of none of configured modes.
+// Middlewares of the package respond with 415 status on the error.
+var ErrNotEncripted = errors.New("request body is not encripted")
+
+// Internal types.
+type (
+	// Decripts request body. Returns request with updated context.
+	// Limit is maximum size of decripted and decompressed data, zero disables the limit.
+	decripter func(r *http.Request, body []byte, limit int64) ([]byte, *http.Request, error)
+	// DecriptMiddleware settings.
+	decriptConfig struct {
+		rsaKey      func() (crypto.Decrypter, error)
+		modes       map[string]decripter
+		replay      *ReplayGuard
+		maxSize     int64
+		maxRatio    int64
+		passthrough bool
+	}
+)
+
+// KeyLookup returns private key by key ID. Empty key ID means the default key.
+// Method Lookup of keys.Ring[crypto.PrivateKey] may be used as KeyLookup.
+type KeyLookup func(kid string) (crypto.PrivateKey, error)
+
+// DecriptOption sets DecriptMiddleware mode.
+type DecriptOption func(*decriptConfig)
+
+// WithRSAKeyFunc enables decription of chunked RSA-OAEP messages.
+// Messages are passed as is when keyFunc returns nil.
+func WithRSAKeyFunc(keyFunc func() *rsa.PrivateKey) DecriptOption {
+	return func(c *decriptConfig) {
+		c.rsaKey = func() (crypto.Decrypter, error) {
+			if key := keyFunc(); key != nil {
+				return key, nil
+			}
+			return nil, nil
+		}
+	}
+}
+
+// WithKeyProvider enables decription of chunked RSA-OAEP messages by
+// the default decrypter of provider. The private key may live outside the process.
+func WithKeyProvider(provider KeyProvider) DecriptOption {
+	return func(c *decriptConfig) {
+		c.rsaKey = func() (crypto.Decrypter, error) {
+			return provider.Decrypter("")
+		}
+	}
+}
+
+// WithDecriptReplayGuard enables replay protection for JWE and X25519 modes.
+// JWE messages must contain "iat" (unix seconds) and "nonce" protected header values.
+// X25519 messages must be created by X25519Encryptor.EncryptWithReplay and sent with
+// "X-Request-Timestamp" and "X-Request-Nonce" headers.
+// Chunked RSA messages can't authenticate these values, so they are not checked.
+func WithDecriptReplayGuard(guard *ReplayGuard) DecriptOption {
+	return func(c *decriptConfig) {
+		c.replay = guard
+	}
+}
+
+// WithDecriptLimits sets limits of decompressed size in bytes and compression ratio for
+// compressed messages (JWE "zip": "DEF"). The size limit is applied to encripted body too.
+// Requests exceeding limits are rejected with 413 status.
+// Zero value disables the limit. Default size limit is 32 MiB, ratio is not limited.
+func WithDecriptLimits(size, ratio int64) DecriptOption {
+	return func(c *decriptConfig) {
+		c.maxSize = size
+		c.maxRatio = ratio
+	}
+}
+
+// WithDecriptPassthrough enables passing of requests with types of none of configured modes
+// (see WithJWE and WithX25519) to handler as is. By default such requests with body are
+// rejected with 415 status, so plain messages can't bypass decription and replay protection.
+// The option is not used when RSA key is set: other types are decripted by RSA key.
+func WithDecriptPassthrough() DecriptOption {
+	return func(c *decriptConfig) {
+		c.passthrough = true
+	}
+}
+
+// newDecriptConfig creates DecriptMiddleware settings.
+func newDecriptConfig(opts ...DecriptOption) *decriptConfig {
+	cfg := decriptConfig{modes: make(map[string]decripter), maxSize: defaultMaxDecompressedSize}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &cfg
+}
+
+// decripter returns decripter for request or nil if request is not encripted.
+func (c *decriptConfig) decripter(r *http.Request) decripter {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get(contentType))
+	if err == nil {
+		if fn, ok := c.modes[mediaType]; ok {
+			return fn
+		}
+	}
+	if c.rsaKey == nil {
+		if len(c.modes) == 0 || c.passthrough {
+			return nil
+		}
+		return func(r *http.Request, body []byte, _ int64) ([]byte, *http.Request, error) {
+			if len(body) == 0 {
+				return body, r, nil
+			}
+			return nil, r, fmt.Errorf("%w: content type '%s'", ErrNotEncripted, r.Header.Get(contentType))
+		}
+	}
+	key, err := c.rsaKey()
+	if key == nil && err == nil {
+		return nil
+	}
+	return func(r *http.Request, body []byte, _ int64) ([]byte, *http.Request, error) {
+		if err != nil {
+			return nil, r, fmt.Errorf("get decrypter error: %w", err)
+		}
+		data, err := decriptMessage(key, body)
+		return data, r, err
+	}
+}
+
 // DecriptMessage internal function.
-func decriptMessage(key *rsa.PrivateKey, msg []byte) ([]byte, error) {
-	size := key.PublicKey.Size()
+func decriptMessage(key crypto.Decrypter, msg []byte) ([]byte, error) {
+	public, ok := key.Public().(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("decrypter key type %T is not rsa", key.Public())
+	}
+	size := public.Size()
 	if len(msg)%size != 0 {
 		return nil, errors.New("message length error")
 	}
-	hash := sha256.New()
+	opts := &rsa.OAEPOptions{Hash: crypto.SHA256}
 	dectipted := make([]byte, 0)
 	for i := 0; i < len(msg); i += size {
-		data, err := rsa.DecryptOAEP(hash, nil, key, msg[i:i+size], []byte(""))
+		data, err := key.Decrypt(rand.Reader, msg[i:i+size], opts)
 		if err != nil {
 			return nil, getError(DecriptMsgError, err)
 		}
@@ -68,27 +195,53 @@ func DecriptMiddleware(
 	key *rsa.PrivateKey,
 	logger *zap.SugaredLogger,
 ) func(h http.Handler) http.Handler {
+	return DecriptMiddlewareFunc(func() *rsa.PrivateKey { return key }, logger)
+}
+
+// DecriptMiddlewareFunc decripts messages from clients by the key returned from keyFunc.
+// The keyFunc is called for every request, so the key may be replaced at runtime
+// (see keys.Watcher).
+func DecriptMiddlewareFunc(
+	keyFunc func() *rsa.PrivateKey,
+	logger *zap.SugaredLogger,
+) func(h http.Handler) http.Handler {
+	return NewDecriptMiddleware(logger, WithRSAKeyFunc(keyFunc))
+}
+
+// NewDecriptMiddleware decripts messages from clients in the modes set by options.
+// Mode is selected by request Content-Type. Requests with other types are decripted
+// by the RSA key (see WithRSAKeyFunc). If RSA key is not set, such requests with body are
+// rejected with 415 status when modes are set (see WithDecriptPassthrough) or passed as is.
+func NewDecriptMiddleware(logger *zap.SugaredLogger, opts ...DecriptOption) func(h http.Handler) http.Handler {
+	cfg := newDecriptConfig(opts...)
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
-			if key != nil && (r.Method == http.MethodPost || r.Method == http.MethodPut) {
-				data, err := io.ReadAll(r.Body)
-				if err != nil {
-					logger.Warnf(getError(ReadBodyError, err).Error())
-					return
-				}
-				if err = r.Body.Close(); err != nil {
-					logger.Warnf(getError(ReadBodyError, err).Error())
-					return
-				}
-				body, err := decriptMessage(key, data)
-				if err != nil {
-					w.WriteHeader(http.StatusBadRequest)
-					logger.Warnf("decript error: %w", err)
-					return
-				}
-				r.Body = io.NopCloser(bytes.NewReader(body))
+			if r.Method != http.MethodPost && r.Method != http.MethodPut {
+				next.ServeHTTP(w, r)
+				return
+			}
+			decript := cfg.decripter(r)
+			if decript == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			data, err := readRequestBody(r, cfg.maxSize)
+			if err != nil {
+				w.WriteHeader(readErrorStatus(err))
+				logger.Warnf(getError(ReadBodyError, err).Error())
+				return
+			}
+			if err = r.Body.Close(); err != nil {
+				logger.Warnf(getError(ReadBodyError, err).Error())
+				return
+			}
+			body, r, err := decript(r, data, cfg.maxSize)
+			if err != nil {
+				w.WriteHeader(readErrorStatus(err))
+				logger.Warnf("decript error: %w", err)
+				return
 			}
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(w, setBody(r, body))
 		}
 		return http.HandlerFunc(fn)
 	}
@@ -0,0 +1,326 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	applicationJOSE  = "application/jose"
+	jweAlgRSAOAEP256 = "RSA-OAEP-256"
+	jweAlgECDHES     = "ECDH-ES"
+	jweEncA256GCM    = "A256GCM"
+	jweZipDeflate    = "DEF"
+	jweKeySize       = 32 // A256GCM key size.
+	jweTagSize       = 16 // A256GCM tag size.
+	jweParts         = 5  // Parts count in compact serialization.
+)
+
+var (
+	ErrJWEInvalid     = errors.New("invalid jwe")         // Incorrect JWE compact serialization.
+	ErrJWEUnsupported = errors.New("unsupported jwe")     // Unsupported JWE algorithm or header.
+	ErrJWEKeyType     = errors.New("unsupported jwe key") // Key type does not match JWE algorithm.
+)
+
+// JWEHeader contains protected header of JWE message.
+// Handlers get it from request context by JOSEHeader key.
+type JWEHeader struct {
+	Params map[string]any `json:"-"`              // All header parameters.
+	EPK    *jwk           `json:"epk,omitempty"`  // Ephemeral public key for ECDH-ES.
+	Alg    string         `json:"alg"`            // Key management algorithm.
+	Enc    string         `json:"enc"`            // Content encryption algorithm.
+	Kid    string         `json:"kid,omitempty"`  // Key ID.
+	Zip    string         `json:"zip,omitempty"`  // Compression algorithm.
+	Cty    string         `json:"cty,omitempty"`  // Content type of payload.
+	APU    string         `json:"apu,omitempty"`  // Agreement PartyUInfo for ECDH-ES.
+	APV    string         `json:"apv,omitempty"`  // Agreement PartyVInfo for ECDH-ES.
+	Crit   []string       `json:"crit,omitempty"` // Critical extensions.
+}
+
+// Public JSON Web Key (RFC 7517) of EC or OKP type.
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y,omitempty"`
+}
+
+// WithJWE enables decription of JWE compact serialization messages
+// with Content-Type: application/jose.
+// Supported key management algorithms are RSA-OAEP-256 (*rsa.PrivateKey or crypto.Decrypter)
+// and ECDH-ES (*ecdsa.PrivateKey or X25519 *ecdh.PrivateKey),
+// content encryption algorithm is A256GCM.
+// The key is selected by "kid" header value.
+func WithJWE(keys KeyLookup) DecriptOption {
+	return func(c *decriptConfig) {
+		c.modes[applicationJOSE] = func(r *http.Request, body []byte, limit int64) ([]byte, *http.Request, error) {
+			data, header, err := decriptJWE(body, keys, limit, c.maxRatio)
+			if err != nil {
+				return nil, r, err
+			}
+			if c.replay != nil {
+				if err = c.replay.Check(header.replayParams()); err != nil {
+					return nil, r, err
+				}
+			}
+			if header.Cty != "" {
+				r.Header.Set(contentType, header.Cty)
+			}
+			return data, r.WithContext(context.WithValue(r.Context(), JOSEHeader, header)), nil
+		}
+	}
+}
+
+// decriptJWE decripts JWE compact serialization message. Compressed content is
+// inflated with size and ratio limits.
+func decriptJWE(msg []byte, keys KeyLookup, maxSize, maxRatio int64) ([]byte, *JWEHeader, error) {
+	parts := strings.Split(string(bytes.TrimSpace(msg)), ".")
+	if len(parts) != jweParts {
+		return nil, nil, fmt.Errorf("%w: parts count %d", ErrJWEInvalid, len(parts))
+	}
+	header, err := parseJWEHeader(parts[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if header.Enc != jweEncA256GCM {
+		return nil, nil, fmt.Errorf("%w: enc '%s'", ErrJWEUnsupported, header.Enc)
+	}
+	key, err := keys(header.Kid)
+	if err != nil {
+		return nil, nil, fmt.Errorf("jwe key '%s' lookup error: %w", header.Kid, err)
+	}
+	encKey, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: encrypted key: %w", ErrJWEInvalid, err)
+	}
+	var cek []byte
+	switch header.Alg {
+	case jweAlgRSAOAEP256:
+		cek, err = jweRSAKey(key, encKey)
+	case jweAlgECDHES:
+		if len(encKey) != 0 {
+			return nil, nil, fmt.Errorf("%w: encrypted key must be empty for %s", ErrJWEInvalid, header.Alg)
+		}
+		cek, err = jweECDHKey(key, header)
+	default:
+		return nil, nil, fmt.Errorf("%w: alg '%s'", ErrJWEUnsupported, header.Alg)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := jweOpen(cek, parts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header.Zip == jweZipDeflate {
+		limits := &decompressLimits{input: &countReader{r: bytes.NewReader(data)}, maxSize: maxSize, maxRatio: maxRatio}
+		reader := &gzipReader{decoder: flate.NewReader(limits.input), limits: limits}
+		data, err = io.ReadAll(reader)
+		var limitErr *DecompressLimitError
+		if errors.As(err, &limitErr) {
+			return nil, nil, limitErr
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: inflate error: %w", ErrJWEInvalid, err)
+		}
+	}
+	return data, header, nil
+}
+
+// replayParams returns "iat" and "nonce" header values.
+func (h *JWEHeader) replayParams() (string, string) {
+	var timestamp, nonce string
+	if iat, ok := h.Params["iat"].(float64); ok {
+		timestamp = strconv.FormatInt(int64(iat), 10)
+	}
+	if value, ok := h.Params["nonce"].(string); ok {
+		nonce = value
+	}
+	return timestamp, nonce
+}
+
+// parseJWEHeader decodes and checks protected header.
+func parseJWEHeader(value string) (*JWEHeader, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: protected header: %w", ErrJWEInvalid, err)
+	}
+	var header JWEHeader
+	if err = json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("%w: protected header: %w", ErrJWEInvalid, err)
+	}
+	if err = json.Unmarshal(data, &header.Params); err != nil {
+		return nil, fmt.Errorf("%w: protected header: %w", ErrJWEInvalid, err)
+	}
+	if len(header.Crit) > 0 {
+		return nil, fmt.Errorf("%w: crit %v", ErrJWEUnsupported, header.Crit)
+	}
+	if header.Zip != "" && header.Zip != jweZipDeflate {
+		return nil, fmt.Errorf("%w: zip '%s'", ErrJWEUnsupported, header.Zip)
+	}
+	return &header, nil
+}
+
+// jweRSAKey decripts content encryption key by RSA-OAEP-256.
+// The key may be any crypto.Decrypter with RSA public key.
+func jweRSAKey(key crypto.PrivateKey, encKey []byte) ([]byte, error) {
+	decrypter, ok := key.(crypto.Decrypter)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T for %s", ErrJWEKeyType, key, jweAlgRSAOAEP256)
+	}
+	if _, ok = decrypter.Public().(*rsa.PublicKey); !ok {
+		return nil, fmt.Errorf("%w: %T for %s", ErrJWEKeyType, decrypter.Public(), jweAlgRSAOAEP256)
+	}
+	cek, err := decrypter.Decrypt(rand.Reader, encKey, &rsa.OAEPOptions{Hash: crypto.SHA256})
+	if err != nil {
+		return nil, getError(DecriptMsgError, err)
+	}
+	return cek, nil
+}
+
+// jweECDHKey derives content encryption key by ECDH-ES direct key agreement.
+func jweECDHKey(key crypto.PrivateKey, header *JWEHeader) ([]byte, error) {
+	var private *ecdh.PrivateKey
+	switch k := key.(type) {
+	case *ecdh.PrivateKey:
+		private = k
+	case *ecdsa.PrivateKey:
+		var err error
+		if private, err = k.ECDH(); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrJWEKeyType, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %T for %s", ErrJWEKeyType, key, jweAlgECDHES)
+	}
+	if header.EPK == nil {
+		return nil, fmt.Errorf("%w: epk is empty", ErrJWEInvalid)
+	}
+	public, err := header.EPK.ecdhPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if public.Curve() != private.Curve() {
+		return nil, fmt.Errorf("%w: epk curve does not match key curve", ErrJWEKeyType)
+	}
+	z, err := private.ECDH(public)
+	if err != nil {
+		return nil, fmt.Errorf("%w: ecdh error: %w", ErrJWEInvalid, err)
+	}
+	apu, err := base64.RawURLEncoding.DecodeString(header.APU)
+	if err != nil {
+		return nil, fmt.Errorf("%w: apu: %w", ErrJWEInvalid, err)
+	}
+	apv, err := base64.RawURLEncoding.DecodeString(header.APV)
+	if err != nil {
+		return nil, fmt.Errorf("%w: apv: %w", ErrJWEInvalid, err)
+	}
+	return concatKDF(z, []byte(header.Enc), apu, apv, jweKeySize), nil
+}
+
+// ecdhPublicKey converts JWK to ECDH public key.
+func (k *jwk) ecdhPublicKey() (*ecdh.PublicKey, error) {
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("%w: epk x: %w", ErrJWEInvalid, err)
+	}
+	if k.Kty == "OKP" && k.Crv == "X25519" {
+		public, err := ecdh.X25519().NewPublicKey(x)
+		if err != nil {
+			return nil, fmt.Errorf("%w: epk: %w", ErrJWEInvalid, err)
+		}
+		return public, nil
+	}
+	var curve ecdh.Curve
+	switch {
+	case k.Kty == "EC" && k.Crv == "P-256":
+		curve = ecdh.P256()
+	case k.Kty == "EC" && k.Crv == "P-384":
+		curve = ecdh.P384()
+	case k.Kty == "EC" && k.Crv == "P-521":
+		curve = ecdh.P521()
+	default:
+		return nil, fmt.Errorf("%w: epk %s %s", ErrJWEUnsupported, k.Kty, k.Crv)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("%w: epk y: %w", ErrJWEInvalid, err)
+	}
+	point := append([]byte{4}, append(x, y...)...) //nolint:gomnd //<-uncompressed point
+	public, err := curve.NewPublicKey(point)
+	if err != nil {
+		return nil, fmt.Errorf("%w: epk: %w", ErrJWEInvalid, err)
+	}
+	return public, nil
+}
+
+// concatKDF derives key according to RFC 7518 section 4.6.2.
+func concatKDF(z, algID, apu, apv []byte, size int) []byte {
+	info := make([]byte, 0, len(algID)+len(apu)+len(apv)+16) //nolint:gomnd //<-lengths size
+	for _, v := range [][]byte{algID, apu, apv} {
+		info = binary.BigEndian.AppendUint32(info, uint32(len(v)))
+		info = append(info, v...)
+	}
+	info = binary.BigEndian.AppendUint32(info, uint32(size*8)) //nolint:gomnd //<-bits count
+	key := make([]byte, 0, size+sha256.Size)
+	for counter := uint32(1); len(key) < size; counter++ {
+		h := sha256.New()
+		h.Write(binary.BigEndian.AppendUint32(nil, counter)) //nolint:errcheck //<-hash never returns error
+		h.Write(z)                                           //nolint:errcheck //<-hash never returns error
+		h.Write(info)                                        //nolint:errcheck //<-hash never returns error
+		key = h.Sum(key)
+	}
+	return key[:size]
+}
+
+// jweOpen decripts JWE ciphertext by A256GCM.
+func jweOpen(cek []byte, parts []string) ([]byte, error) {
+	if len(cek) != jweKeySize {
+		return nil, fmt.Errorf("%w: content encryption key size %d", ErrJWEInvalid, len(cek))
+	}
+	values := make([][]byte, 0, len(parts)-2) //nolint:gomnd //<-header and key are decoded
+	for _, part := range parts[2:] {
+		value, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrJWEInvalid, err)
+		}
+		values = append(values, value)
+	}
+	iv, ciphertext, tag := values[0], values[1], values[2]
+	if len(tag) != jweTagSize {
+		return nil, fmt.Errorf("%w: tag size %d", ErrJWEInvalid, len(tag))
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrJWEInvalid, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrJWEInvalid, err)
+	}
+	if len(iv) != aead.NonceSize() {
+		return nil, fmt.Errorf("%w: iv size %d", ErrJWEInvalid, len(iv))
+	}
+	data, err := aead.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return nil, getError(DecriptMsgError, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,264 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// encriptJWE creates JWE compact serialization for tests.
+func encriptJWE(t *testing.T, header map[string]any, key crypto.PublicKey, data []byte) string {
+	t.Helper()
+	var cek, encKey []byte
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		cek = make([]byte, jweKeySize)
+		_, err := rand.Read(cek)
+		assert.NoError(t, err)
+		encKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, k, cek, nil)
+		assert.NoError(t, err)
+	case *ecdh.PublicKey:
+		private, err := k.Curve().GenerateKey(rand.Reader)
+		assert.NoError(t, err)
+		epk := jwk{Kty: "OKP", Crv: "X25519", X: base64.RawURLEncoding.EncodeToString(private.PublicKey().Bytes())}
+		if k.Curve() == ecdh.P256() {
+			point := private.PublicKey().Bytes()
+			epk = jwk{
+				Kty: "EC", Crv: "P-256",
+				X: base64.RawURLEncoding.EncodeToString(point[1:33]),
+				Y: base64.RawURLEncoding.EncodeToString(point[33:]),
+			}
+		}
+		header["epk"] = epk
+		z, err := private.ECDH(k)
+		assert.NoError(t, err)
+		cek = concatKDF(z, []byte(jweEncA256GCM), nil, nil, jweKeySize)
+	}
+	if header["zip"] == jweZipDeflate {
+		var buf bytes.Buffer
+		w, err := flate.NewWriter(&buf, flate.BestCompression)
+		assert.NoError(t, err)
+		_, err = w.Write(data)
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+		data = buf.Bytes()
+	}
+	protected, err := json.Marshal(header)
+	assert.NoError(t, err)
+	aad := base64.RawURLEncoding.EncodeToString(protected)
+	block, err := aes.NewCipher(cek)
+	assert.NoError(t, err)
+	aead, err := cipher.NewGCM(block)
+	assert.NoError(t, err)
+	iv := make([]byte, aead.NonceSize())
+	_, err = rand.Read(iv)
+	assert.NoError(t, err)
+	sealed := aead.Seal(nil, iv, data, []byte(aad))
+	enc := base64.RawURLEncoding.EncodeToString
+	return strings.Join([]string{
+		aad, enc(encKey), enc(iv), enc(sealed[:len(sealed)-jweTagSize]), enc(sealed[len(sealed)-jweTagSize:]),
+	}, ".")
+}
+
+func testKeyLookup(keys map[string]crypto.PrivateKey) KeyLookup {
+	return func(kid string) (crypto.PrivateKey, error) {
+		if key, ok := keys[kid]; ok {
+			return key, nil
+		}
+		return nil, errors.New("key not found")
+	}
+}
+
+func Test_decriptJWE(t *testing.T) {
+	data := []byte(strings.Repeat("test data ", 10))
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	ecdhKey, err := ecKey.ECDH()
+	assert.NoError(t, err)
+	xKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	lookup := testKeyLookup(map[string]crypto.PrivateKey{"rsa": rsaKey, "ec": ecKey, "x": xKey})
+	tests := []struct {
+		name    string
+		header  map[string]any
+		key     crypto.PublicKey
+		wantErr error
+	}{
+		{
+			name:   "RSA-OAEP-256",
+			header: map[string]any{"alg": jweAlgRSAOAEP256, "enc": jweEncA256GCM, "kid": "rsa"},
+			key:    &rsaKey.PublicKey,
+		},
+		{
+			name:   "RSA-OAEP-256 with deflate",
+			header: map[string]any{"alg": jweAlgRSAOAEP256, "enc": jweEncA256GCM, "kid": "rsa", "zip": jweZipDeflate},
+			key:    &rsaKey.PublicKey,
+		},
+		{
+			name:   "ECDH-ES P-256",
+			header: map[string]any{"alg": jweAlgECDHES, "enc": jweEncA256GCM, "kid": "ec"},
+			key:    ecdhKey.PublicKey(),
+		},
+		{
+			name:   "ECDH-ES X25519",
+			header: map[string]any{"alg": jweAlgECDHES, "enc": jweEncA256GCM, "kid": "x"},
+			key:    xKey.PublicKey(),
+		},
+		{
+			name:    "Unsupported alg",
+			header:  map[string]any{"alg": "RSA1_5", "enc": jweEncA256GCM, "kid": "rsa"},
+			key:     &rsaKey.PublicKey,
+			wantErr: ErrJWEUnsupported,
+		},
+		{
+			name:    "Unsupported enc",
+			header:  map[string]any{"alg": jweAlgRSAOAEP256, "enc": "A128CBC-HS256", "kid": "rsa"},
+			key:     &rsaKey.PublicKey,
+			wantErr: ErrJWEUnsupported,
+		},
+		{
+			name:    "Unsupported zip",
+			header:  map[string]any{"alg": jweAlgRSAOAEP256, "enc": jweEncA256GCM, "kid": "rsa", "zip": "GZ"},
+			key:     &rsaKey.PublicKey,
+			wantErr: ErrJWEUnsupported,
+		},
+		{
+			name:    "Key type mismatch",
+			header:  map[string]any{"alg": jweAlgECDHES, "enc": jweEncA256GCM, "kid": "rsa"},
+			key:     ecdhKey.PublicKey(),
+			wantErr: ErrJWEKeyType,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			msg := encriptJWE(t, tt.header, tt.key, data)
+			got, header, err := decriptJWE([]byte(msg), lookup, defaultMaxDecompressedSize, 0)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err, "decript jwe error")
+			assert.Equal(t, data, got, "decripted data not equal")
+			assert.Equal(t, tt.header["kid"], header.Kid)
+		})
+	}
+}
+
+func Test_decriptJWE_tampered(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	lookup := testKeyLookup(map[string]crypto.PrivateKey{"": key})
+	msg := encriptJWE(t, map[string]any{"alg": jweAlgRSAOAEP256, "enc": jweEncA256GCM}, &key.PublicKey, []byte("data"))
+	parts := strings.Split(msg, ".")
+	header, err := json.Marshal(map[string]any{"alg": jweAlgRSAOAEP256, "enc": jweEncA256GCM, "kid": ""})
+	assert.NoError(t, err)
+	parts[0] = base64.RawURLEncoding.EncodeToString(header)
+	_, _, err = decriptJWE([]byte(strings.Join(parts, ".")), lookup, 0, 0)
+	assert.Error(t, err, "tampered header accepted")
+	_, _, err = decriptJWE([]byte(strings.Join(parts[1:], ".")), lookup, 0, 0)
+	assert.ErrorIs(t, err, ErrJWEInvalid)
+}
+
+func Test_concatKDF(t *testing.T) {
+	// RFC 7518 appendix C.
+	z := []byte{158, 86, 217, 29, 129, 113, 53, 211, 114, 131, 66, 131, 191, 132, 38, 156,
+		251, 49, 110, 163, 218, 128, 106, 72, 246, 218, 167, 121, 140, 254, 144, 196}
+	key := concatKDF(z, []byte("A128GCM"), []byte("Alice"), []byte("Bob"), 16)
+	if got := base64.RawURLEncoding.EncodeToString(key); got != "VqqN6vgjbSBcIijNcacQGg" {
+		t.Errorf("concatKDF() = %s, want VqqN6vgjbSBcIijNcacQGg", got)
+	}
+}
+
+func TestNewDecriptMiddleware_JWE(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	logger := zap.NewNop().Sugar()
+	var gotHeader *JWEHeader
+	var gotBody []byte
+	handler := NewDecriptMiddleware(logger, WithJWE(testKeyLookup(map[string]crypto.PrivateKey{"k1": key})))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotHeader, _ = r.Context().Value(JOSEHeader).(*JWEHeader) //nolint:errcheck //<-checked below
+			gotBody, err = io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, applicationJSON, r.Header.Get(contentType))
+		}))
+	msg := encriptJWE(t, map[string]any{
+		"alg": jweAlgRSAOAEP256, "enc": jweEncA256GCM, "kid": "k1", "cty": applicationJSON,
+	}, &key.PublicKey, []byte(`{"id":1}`))
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(msg))
+	r.Header.Set(contentType, applicationJOSE)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []byte(`{"id":1}`), gotBody)
+	if assert.NotNil(t, gotHeader, "jwe header not in context") {
+		assert.Equal(t, "k1", gotHeader.Kid)
+		assert.Equal(t, "k1", gotHeader.Params["kid"])
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("a.b.c.d.e"))
+	r.Header.Set(contentType, applicationJOSE)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "broken jwe accepted")
+}
+
+func TestNewDecriptMiddleware_JWEInflateLimits(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	lookup := testKeyLookup(map[string]crypto.PrivateKey{"k1": key})
+	header := map[string]any{"alg": jweAlgRSAOAEP256, "enc": jweEncA256GCM, "kid": "k1", "zip": jweZipDeflate}
+	bomb := encriptJWE(t, header, &key.PublicKey, make([]byte, 8<<20))
+	tests := []struct {
+		name   string
+		opts   []DecriptOption
+		msg    string
+		status int
+	}{
+		{name: "Default size limit", msg: encriptJWE(t, header, &key.PublicKey, make([]byte, defaultMaxDecompressedSize+1)), status: http.StatusRequestEntityTooLarge},
+		{name: "Size limit", opts: []DecriptOption{WithDecriptLimits(1<<20, 0)}, msg: bomb, status: http.StatusRequestEntityTooLarge},
+		{name: "Ratio limit", opts: []DecriptOption{WithDecriptLimits(0, 100)}, msg: bomb, status: http.StatusRequestEntityTooLarge},
+		{name: "Under limits", opts: []DecriptOption{WithDecriptLimits(8<<20, 0)}, msg: bomb, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var size int
+			handler := NewDecriptMiddleware(zap.NewNop().Sugar(), append([]DecriptOption{WithJWE(lookup)}, tt.opts...)...)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					body, err := io.ReadAll(r.Body)
+					assert.NoError(t, err)
+					size = len(body)
+				}))
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.msg))
+			r.Header.Set(contentType, applicationJOSE)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status != http.StatusOK {
+				assert.Zero(t, size, "handler got inflated body")
+			}
+		})
+	}
+}
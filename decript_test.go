@@ -1,11 +1,21 @@
 package middlewares
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 )
 
 func Test_decriptMessage(t *testing.T) {
@@ -29,3 +39,63 @@ func Test_decriptMessage(t *testing.T) {
 		t.Errorf("decription errror. Decript value not equal to manual: %s", string(decr))
 	}
 }
+
+func TestNewDecriptMiddleware_bodyLimit(t *testing.T) {
+	const limit = 512
+	data := []byte(strings.Repeat("data", 256))
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	x25519Key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	lookup := testKeyLookup(map[string]crypto.PrivateKey{"rsa": rsaKey, "x25519": x25519Key})
+
+	var chunked []byte
+	for i := 0; i < len(data); i += 128 {
+		chunk, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &rsaKey.PublicKey, data[i:i+128], nil)
+		assert.NoError(t, err)
+		chunked = append(chunked, chunk...)
+	}
+	jwe := encriptJWE(t, map[string]any{"alg": jweAlgRSAOAEP256, "enc": jweEncA256GCM, "kid": "rsa"}, &rsaKey.PublicKey, data)
+	enc, err := NewX25519Encryptor("x25519", x25519Key.PublicKey())
+	assert.NoError(t, err)
+	sealed, err := enc.Encrypt(data)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		opt   DecriptOption
+		ctype string
+		msg   []byte
+	}{
+		{name: "RSA", opt: WithRSAKeyFunc(func() *rsa.PrivateKey { return rsaKey }), ctype: applicationJSON, msg: chunked},
+		{name: "JWE", opt: WithJWE(lookup), ctype: applicationJOSE, msg: []byte(jwe)},
+		{name: "X25519", opt: WithX25519(lookup), ctype: enc.ContentType(), msg: sealed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, size := range []int64{0, limit} {
+				called := false
+				handler := NewDecriptMiddleware(zap.NewNop().Sugar(), tt.opt, WithDecriptLimits(size, 0))(
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						called = true
+						body, err := io.ReadAll(r.Body)
+						assert.NoError(t, err)
+						assert.Equal(t, data, body)
+					}))
+				body := &countReader{r: bytes.NewReader(tt.msg)}
+				r := httptest.NewRequest(http.MethodPost, "/", io.NopCloser(body))
+				r.Header.Set(contentType, tt.ctype)
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				if size == 0 {
+					assert.Equal(t, http.StatusOK, w.Code, "message without limit")
+					assert.True(t, called, "handler is not called")
+					continue
+				}
+				assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+				assert.False(t, called, "handler got oversized body")
+				assert.LessOrEqual(t, body.n, int64(limit+1), "body read over limit")
+			}
+		})
+	}
+}
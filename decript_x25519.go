@@ -0,0 +1,196 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	applicationX25519 = "application/x-x25519-aesgcm"
+	x25519Version     = 1
+	x25519KeySize     = 32
+	x25519NonceSize   = 12
+	x25519MaxKidSize  = 255
+	x25519Info        = "middlewares x25519 aes-256-gcm v1"
+)
+
+var ErrX25519Invalid = errors.New("invalid x25519 message") // Incorrect X25519 message format.
+
+// X25519Encryptor encripts messages for DecriptMiddleware in X25519 mode (see WithX25519).
+//
+// Message format:
+//
+//	version (1 byte) | kid length (1 byte) | kid | ephemeral public key (32 bytes) |
+//	nonce (12 bytes) | AES-256-GCM ciphertext and tag
+//
+// The AES key is derived by HKDF-SHA256 from the X25519 shared secret with
+// salt = ephemeral public key | recipient public key. All bytes before ciphertext
+// are authenticated as additional data together with replay protection values.
+type X25519Encryptor struct {
+	public *ecdh.PublicKey
+	kid    string
+}
+
+// NewX25519Encryptor creates encryptor for recipient X25519 public key with key ID.
+func NewX25519Encryptor(kid string, public *ecdh.PublicKey) (*X25519Encryptor, error) {
+	if public == nil || public.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("%w: public key must be X25519 key", ErrX25519Invalid)
+	}
+	if len(kid) > x25519MaxKidSize {
+		return nil, fmt.Errorf("%w: key id is too long", ErrX25519Invalid)
+	}
+	return &X25519Encryptor{public: public, kid: kid}, nil
+}
+
+// ContentType returns Content-Type for encripted messages.
+func (e *X25519Encryptor) ContentType() string {
+	return applicationX25519
+}
+
+// Encrypt encripts data with new ephemeral key.
+func (e *X25519Encryptor) Encrypt(data []byte) ([]byte, error) {
+	return e.EncryptWithReplay(data, ReplayParams{})
+}
+
+// EncryptWithReplay encripts data and authenticates replay protection values.
+// The values must be sent in request headers (see ReplayParams.SetHeaders).
+func (e *X25519Encryptor) EncryptWithReplay(data []byte, params ReplayParams) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate ephemeral key error: %w", err)
+	}
+	nonce := make([]byte, x25519NonceSize)
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce error: %w", err)
+	}
+	return e.seal(ephemeral, nonce, data, params.bytes())
+}
+
+// seal internal function.
+func (e *X25519Encryptor) seal(ephemeral *ecdh.PrivateKey, nonce, data, extra []byte) ([]byte, error) {
+	aead, err := x25519AEAD(ephemeral, e.public, x25519Salt(ephemeral.PublicKey(), e.public))
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, 0, 2+len(e.kid)+x25519KeySize+len(nonce)+len(data)+aead.Overhead()) //nolint:gomnd //<-version and kid length
+	msg = append(msg, x25519Version, byte(len(e.kid)))
+	msg = append(msg, e.kid...)
+	msg = append(msg, ephemeral.PublicKey().Bytes()...)
+	msg = append(msg, nonce...)
+	return aead.Seal(msg, nonce, data, append(msg[:len(msg):len(msg)], extra...)), nil
+}
+
+// WithX25519 enables decription of messages with Content-Type: application/x-x25519-aesgcm
+// created by X25519Encryptor.
+// The key is selected by key ID from message and must be X25519 *ecdh.PrivateKey.
+// The key ID is available to handlers in request context by DecriptKeyID key.
+func WithX25519(keys KeyLookup) DecriptOption {
+	return func(c *decriptConfig) {
+		c.modes[applicationX25519] = func(r *http.Request, body []byte, _ int64) ([]byte, *http.Request, error) {
+			params := replayParams(r)
+			data, kid, err := decriptX25519(body, keys, params.bytes())
+			if err != nil {
+				return nil, r, err
+			}
+			if c.replay != nil {
+				if err = c.replay.Check(params.Timestamp, params.Nonce); err != nil {
+					return nil, r, err
+				}
+			}
+			return data, r.WithContext(context.WithValue(r.Context(), DecriptKeyID, kid)), nil
+		}
+	}
+}
+
+// decriptX25519 decripts message created by X25519Encryptor.
+// The extra value is authenticated as additional data after message header.
+func decriptX25519(msg []byte, keys KeyLookup, extra []byte) ([]byte, string, error) {
+	if len(msg) < 2 || msg[0] != x25519Version {
+		return nil, "", fmt.Errorf("%w: unsupported version", ErrX25519Invalid)
+	}
+	headerSize := 2 + int(msg[1]) + x25519KeySize + x25519NonceSize //nolint:gomnd //<-version and kid length
+	if len(msg) < headerSize {
+		return nil, "", fmt.Errorf("%w: message is too short", ErrX25519Invalid)
+	}
+	kid := string(msg[2 : 2+msg[1]])
+	key, err := keys(kid)
+	if err != nil {
+		return nil, "", fmt.Errorf("x25519 key '%s' lookup error: %w", kid, err)
+	}
+	private, ok := key.(*ecdh.PrivateKey)
+	if !ok || private.Curve() != ecdh.X25519() {
+		return nil, "", fmt.Errorf("%w: key '%s' is not X25519 key", ErrX25519Invalid, kid)
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(msg[headerSize-x25519NonceSize-x25519KeySize : headerSize-x25519NonceSize])
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: ephemeral key: %w", ErrX25519Invalid, err)
+	}
+	aead, err := x25519AEAD(private, ephemeral, x25519Salt(ephemeral, private.PublicKey()))
+	if err != nil {
+		return nil, "", err
+	}
+	aad := append(msg[:headerSize:headerSize], extra...)
+	data, err := aead.Open(nil, msg[headerSize-x25519NonceSize:headerSize], msg[headerSize:], aad)
+	if err != nil {
+		return nil, "", getError(DecriptMsgError, err)
+	}
+	return data, kid, nil
+}
+
+// x25519Salt returns HKDF salt: ephemeral public key | recipient public key.
+func x25519Salt(ephemeral, recipient *ecdh.PublicKey) []byte {
+	return append(append(make([]byte, 0, 2*x25519KeySize), ephemeral.Bytes()...), recipient.Bytes()...)
+}
+
+// x25519AEAD creates AES-256-GCM cipher with key derived from X25519 shared secret.
+func x25519AEAD(private *ecdh.PrivateKey, peer *ecdh.PublicKey, salt []byte) (cipher.AEAD, error) {
+	shared, err := private.ECDH(peer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: ecdh error: %w", ErrX25519Invalid, err)
+	}
+	key, err := hkdf(shared, salt, []byte(x25519Info), x25519KeySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher error: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create gcm error: %w", err)
+	}
+	return aead, nil
+}
+
+// hkdf derives key according to RFC 5869 with SHA-256.
+func hkdf(secret, salt, info []byte, size int) ([]byte, error) {
+	if size > 255*sha256.Size { //nolint:gomnd //<-RFC 5869 limit
+		return nil, errors.New("hkdf key size is too large")
+	}
+	if len(salt) == 0 {
+		salt = make([]byte, sha256.Size)
+	}
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret) //nolint:errcheck //<-hash never returns error
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	key := make([]byte, 0, size+sha256.Size)
+	var block []byte
+	for counter := byte(1); len(key) < size; counter++ {
+		expand.Reset()
+		expand.Write(block)           //nolint:errcheck //<-hash never returns error
+		expand.Write(info)            //nolint:errcheck //<-hash never returns error
+		expand.Write([]byte{counter}) //nolint:errcheck //<-hash never returns error
+		block = expand.Sum(block[:0])
+		key = append(key, block...)
+	}
+	return key[:size], nil
+}
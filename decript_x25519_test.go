@@ -0,0 +1,154 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func mustHex(t *testing.T, value string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(value)
+	assert.NoError(t, err, "decode hex error")
+	return data
+}
+
+func Test_hkdf(t *testing.T) {
+	// RFC 5869 test case 1.
+	key, err := hkdf(
+		mustHex(t, "0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b"),
+		mustHex(t, "000102030405060708090a0b0c"),
+		mustHex(t, "f0f1f2f3f4f5f6f7f8f9"),
+		42,
+	)
+	assert.NoError(t, err)
+	want := "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865"
+	assert.Equal(t, want, hex.EncodeToString(key))
+}
+
+func TestX25519Encryptor_vectors(t *testing.T) {
+	// Keys from RFC 7748 section 6.1.
+	alice, err := ecdh.X25519().NewPrivateKey(mustHex(t, "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a"))
+	assert.NoError(t, err)
+	bob, err := ecdh.X25519().NewPrivateKey(mustHex(t, "5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb"))
+	assert.NoError(t, err)
+	assert.Equal(t, "de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f", hex.EncodeToString(bob.PublicKey().Bytes()))
+	shared, err := alice.ECDH(bob.PublicKey())
+	assert.NoError(t, err)
+	assert.Equal(t, "4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742", hex.EncodeToString(shared))
+
+	enc, err := NewX25519Encryptor("k1", bob.PublicKey())
+	assert.NoError(t, err)
+	msg, err := enc.seal(alice, mustHex(t, "000102030405060708090a0b"), []byte("test"), nil)
+	assert.NoError(t, err)
+	want := "01026b31" +
+		"8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a" +
+		"000102030405060708090a0b" +
+		"f4281ae0658a34a199005a61b33db12d2f1342eb"
+	assert.Equal(t, want, hex.EncodeToString(msg), "message is not equal to test vector")
+
+	data, kid, err := decriptX25519(msg, testKeyLookup(map[string]crypto.PrivateKey{"k1": bob}), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "k1", kid)
+	assert.Equal(t, []byte("test"), data)
+}
+
+func Test_decriptX25519(t *testing.T) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	other, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	lookup := testKeyLookup(map[string]crypto.PrivateKey{"k1": key, "k2": other})
+	enc, err := NewX25519Encryptor("k1", key.PublicKey())
+	assert.NoError(t, err)
+	msg, err := enc.Encrypt([]byte("data"))
+	assert.NoError(t, err)
+
+	data, kid, err := decriptX25519(msg, lookup, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "k1", kid)
+	assert.Equal(t, []byte("data"), data)
+
+	tampered := bytes.Clone(msg)
+	tampered[3] = '2' // kid k1 -> k2
+	_, _, err = decriptX25519(tampered, lookup, nil)
+	assert.Error(t, err, "message with changed kid decripted")
+
+	tampered = bytes.Clone(msg)
+	tampered[len(tampered)-1] ^= 1
+	_, _, err = decriptX25519(tampered, lookup, nil)
+	assert.Error(t, err, "message with changed tag decripted")
+
+	_, _, err = decriptX25519(msg[:10], lookup, nil)
+	assert.ErrorIs(t, err, ErrX25519Invalid)
+
+	_, err = NewX25519Encryptor("k1", nil)
+	assert.ErrorIs(t, err, ErrX25519Invalid)
+}
+
+func TestNewDecriptMiddleware_X25519(t *testing.T) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	enc, err := NewX25519Encryptor("k1", key.PublicKey())
+	assert.NoError(t, err)
+	msg, err := enc.Encrypt([]byte("data"))
+	assert.NoError(t, err)
+	var gotBody []byte
+	var gotKid any
+	handler := NewDecriptMiddleware(
+		zap.NewNop().Sugar(),
+		WithX25519(testKeyLookup(map[string]crypto.PrivateKey{"k1": key})),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKid = r.Context().Value(DecriptKeyID)
+		gotBody, err = io.ReadAll(r.Body)
+		assert.NoError(t, err)
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(msg))
+	r.Header.Set(contentType, enc.ContentType())
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []byte("data"), gotBody)
+	assert.Equal(t, "k1", gotKid)
+}
+
+func TestNewDecriptMiddleware_notEncripted(t *testing.T) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	lookup := testKeyLookup(map[string]crypto.PrivateKey{"k1": key})
+	tests := []struct {
+		name   string
+		body   string
+		opts   []DecriptOption
+		status int
+		called bool
+	}{
+		{name: "Plain body", body: `{"id":"metric"}`, status: http.StatusUnsupportedMediaType},
+		{name: "Empty body", status: http.StatusOK, called: true},
+		{name: "Passthrough", body: `{"id":"metric"}`, opts: []DecriptOption{WithDecriptPassthrough()}, status: http.StatusOK, called: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := NewDecriptMiddleware(zap.NewNop().Sugar(), append([]DecriptOption{WithX25519(lookup)}, tt.opts...)...)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					called = true
+				}))
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(tt.body)))
+			r.Header.Set(contentType, applicationJSON)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.called, called)
+		})
+	}
+}
@@ -0,0 +1,404 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	contentDigest     = "Content-Digest"
+	reprDigest        = "Repr-Digest"
+	wantContentDigest = "Want-Content-Digest"
+	wantReprDigest    = "Want-Repr-Digest"
+
+	DigestSHA256 = "sha-256" // SHA-256 digest algorithm.
+	DigestSHA512 = "sha-512" // SHA-512 digest algorithm.
+)
+
+// Digest errors.
+var (
+	ErrDigestMissing     = errors.New("content digest is required")      // Request has body, but has not Content-Digest.
+	ErrDigestUnsupported = errors.New("digest algorithm is unsupported") // Digest has not supported algorithms.
+	ErrDigestMismatch    = errors.New("incorrect content digest")        // Digest is not equal to body digest.
+)
+
+// Supported digest algorithms. Insecure algorithms (md5, sha, etc.) are not supported.
+var digestAlgorithms = map[string]func() hash.Hash{
+	DigestSHA256: sha256.New,
+	DigestSHA512: sha512.New,
+}
+
+// Internal types.
+type (
+	// NewDigestMiddleware settings.
+	digestConfig struct {
+		algorithms []string
+		maxSize    int64
+		required   bool
+		repr       bool
+		trailer    bool
+	}
+	// Digest of data by one algorithm.
+	digestHash struct {
+		hash.Hash
+		alg string
+	}
+	// Struct for write response digests.
+	// Digests are calculated once while data is written.
+	digestWriter struct {
+		http.ResponseWriter
+		hashes      []digestHash
+		body        []byte
+		status      int
+		repr        bool
+		trailer     bool
+		skip        bool
+		wroteHeader bool
+	}
+)
+
+// DigestOption sets NewDigestMiddleware settings.
+type DigestOption func(*digestConfig)
+
+// WithDigestAlgorithms sets algorithms of response digests, used when client does not send
+// Want-Content-Digest (Want-Repr-Digest) preferences. Unsupported algorithms are ignored.
+// Default algorithm is "sha-256".
+func WithDigestAlgorithms(algorithms ...string) DigestOption {
+	return func(c *digestConfig) {
+		c.algorithms = make([]string, 0, len(algorithms))
+		for _, alg := range algorithms {
+			if _, ok := digestAlgorithms[alg]; ok {
+				c.algorithms = append(c.algorithms, alg)
+			}
+		}
+	}
+}
+
+// WithRequiredDigest enables rejection of requests with body and without Content-Digest.
+// Such requests are rejected with 400 status and Want-Content-Digest header.
+func WithRequiredDigest() DigestOption {
+	return func(c *digestConfig) {
+		c.required = true
+	}
+}
+
+// WithReprDigest enables Repr-Digest header of responses. The header is also sent
+// when client sends Want-Repr-Digest preferences.
+func WithReprDigest() DigestOption {
+	return func(c *digestConfig) {
+		c.repr = true
+	}
+}
+
+// WithDigestMaxSize sets limit of request body size in bytes. Larger requests
+// are rejected with 413 status. Zero size disables the limit. Default is 32 MiB.
+func WithDigestMaxSize(size int64) DigestOption {
+	return func(c *digestConfig) {
+		c.maxSize = size
+	}
+}
+
+// WithDigestTrailer enables sending of response digests in HTTP trailer.
+// Response body is streamed to client. By default response body is buffered.
+func WithDigestTrailer() DigestOption {
+	return func(c *digestConfig) {
+		c.trailer = true
+	}
+}
+
+// ContentDigest returns Content-Digest (Repr-Digest) header value of data.
+// Default algorithm is "sha-256".
+func ContentDigest(data []byte, algorithms ...string) (string, error) {
+	if len(algorithms) == 0 {
+		algorithms = []string{DigestSHA256}
+	}
+	hashes, err := newDigestHashes(algorithms)
+	if err != nil {
+		return "", err
+	}
+	for _, h := range hashes {
+		h.Write(data) //nolint:errcheck //<-hash.Hash never returns error
+	}
+	return digestValue(hashes), nil
+}
+
+// newDigestHashes creates hashes for algorithms.
+func newDigestHashes(algorithms []string) ([]digestHash, error) {
+	hashes := make([]digestHash, 0, len(algorithms))
+	for _, alg := range algorithms {
+		fn, ok := digestAlgorithms[alg]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrDigestUnsupported, alg)
+		}
+		hashes = append(hashes, digestHash{Hash: fn(), alg: alg})
+	}
+	return hashes, nil
+}
+
+// digestValue returns header value of calculated digests.
+func digestValue(hashes []digestHash) string {
+	list := make([]string, 0, len(hashes))
+	for _, h := range hashes {
+		list = append(list, h.alg+"="+serializeSFBareItem(h.Sum(nil)))
+	}
+	return strings.Join(list, ", ")
+}
+
+// verifyDigest checks digest header value of data. Every supported algorithm of
+// the value is checked, and at least one algorithm must be supported.
+func verifyDigest(data []byte, value string) error {
+	members, err := parseSFDictionary(value)
+	if err != nil {
+		return fmt.Errorf("digest parse error: %w", err)
+	}
+	checked := 0
+	for _, item := range members {
+		fn, ok := digestAlgorithms[item.name]
+		if !ok {
+			continue
+		}
+		sum, ok := item.item.value.([]byte)
+		if !ok {
+			return fmt.Errorf("%w: %s is not byte sequence", ErrDigestMismatch, item.name)
+		}
+		h := fn()
+		h.Write(data) //nolint:errcheck //<-hash.Hash never returns error
+		if subtle.ConstantTimeCompare(sum, h.Sum(nil)) != 1 {
+			return fmt.Errorf("%w: %s", ErrDigestMismatch, item.name)
+		}
+		checked++
+	}
+	if checked == 0 {
+		return fmt.Errorf("%w: %s", ErrDigestUnsupported, value)
+	}
+	return nil
+}
+
+// preferredDigest returns the most preferred supported algorithm of
+// Want-Content-Digest (Want-Repr-Digest) header value or default algorithms.
+func (c *digestConfig) preferredDigest(want string) []string {
+	members, err := parseSFDictionary(want)
+	if want == "" || err != nil {
+		return c.algorithms
+	}
+	alg, weight := "", int64(0)
+	for _, item := range members {
+		value, ok := item.item.value.(int64)
+		if _, supported := digestAlgorithms[item.name]; supported && ok && value > weight {
+			alg, weight = item.name, value
+		}
+	}
+	if alg == "" {
+		return c.algorithms
+	}
+	return []string{alg}
+}
+
+// wantDigest returns Want-Content-Digest header value with default algorithms.
+func (c *digestConfig) wantDigest() string {
+	list := make([]string, 0, len(c.algorithms))
+	for i, alg := range c.algorithms {
+		weight := 10 - i //nolint:gomnd //<-max preference
+		if weight < 1 {
+			weight = 1
+		}
+		list = append(list, alg+"="+strconv.Itoa(weight))
+	}
+	return strings.Join(list, ", ")
+}
+
+// check checks Content-Digest and Repr-Digest of request body.
+// Repr-Digest of partial content can't be checked and is ignored.
+func (c *digestConfig) check(r *http.Request, data []byte) error {
+	content := r.Header.Get(contentDigest)
+	if content == "" && c.required && len(data) > 0 {
+		return ErrDigestMissing
+	}
+	if content != "" {
+		if err := verifyDigest(data, content); err != nil {
+			return err
+		}
+	}
+	if repr := r.Header.Get(reprDigest); repr != "" && r.Header.Get(contentRange) == "" {
+		return verifyDigest(data, repr)
+	}
+	return nil
+}
+
+// newDigestWriter creates writer for response digests.
+func (c *digestConfig) newDigestWriter(w http.ResponseWriter, r *http.Request) *digestWriter {
+	dw := &digestWriter{ResponseWriter: w, trailer: c.trailer, skip: r.Method == http.MethodHead}
+	algorithms := c.preferredDigest(r.Header.Get(wantContentDigest))
+	want := r.Header.Get(wantReprDigest)
+	dw.repr = c.repr || want != ""
+	if want != "" {
+		algorithms = appendMissing(algorithms, c.preferredDigest(want)...)
+	}
+	dw.hashes, _ = newDigestHashes(algorithms) //nolint:errcheck //<-algorithms are supported
+	return dw
+}
+
+// appendMissing appends values which are not in list.
+func appendMissing(list []string, values ...string) []string {
+	result := append(make([]string, 0, len(list)+len(values)), list...)
+	for _, value := range values {
+		found := false
+		for _, item := range result {
+			found = found || item == value
+		}
+		if !found {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// Write calculates digests of body. Body is buffered if digests are sent in header.
+func (r *digestWriter) Write(b []byte) (int, error) {
+	if !r.wroteHeader && r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	if r.skip {
+		return r.write(b)
+	}
+	for _, h := range r.hashes {
+		h.Write(b) //nolint:errcheck //<-hash.Hash never returns error
+	}
+	if !r.trailer {
+		r.body = append(r.body, b...)
+		return len(b), nil
+	}
+	return r.write(b)
+}
+
+// write writes data to the underlying writer.
+func (r *digestWriter) write(b []byte) (int, error) {
+	size, err := r.ResponseWriter.Write(b)
+	if err != nil {
+		return 0, fmt.Errorf("response write error: %w", err)
+	}
+	return size, nil
+}
+
+// WriteHeader declares digest trailers in trailer mode. In buffer mode
+// status is sent by Close. Responses without body have not digests.
+func (r *digestWriter) WriteHeader(statusCode int) {
+	if statusCode >= http.StatusContinue && statusCode < http.StatusOK {
+		r.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if r.status != 0 {
+		return
+	}
+	r.status = statusCode
+	r.repr = r.repr && statusCode != http.StatusPartialContent
+	r.skip = r.skip || len(r.hashes) == 0 || !bodyAllowed(statusCode) && statusCode != http.StatusPartialContent
+	if r.trailer && !r.skip {
+		r.Header().Add("Trailer", contentDigest)
+		if r.repr {
+			r.Header().Add("Trailer", reprDigest)
+		}
+		r.Header().Del(contentLength)
+	}
+	if r.trailer || r.skip {
+		r.wroteHeader = true
+		r.ResponseWriter.WriteHeader(statusCode)
+	}
+}
+
+// Flush sends data to client in trailer mode. Buffered body can't be flushed
+// before digests are calculated.
+func (r *digestWriter) Flush() {
+	if !r.trailer && !r.skip {
+		return
+	}
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close sets digests of the full body in header or trailer and sends buffered body.
+func (r *digestWriter) Close() error {
+	if r.status == 0 || r.skip {
+		return nil
+	}
+	value := digestValue(r.hashes)
+	r.Header().Set(contentDigest, value)
+	if r.repr {
+		r.Header().Set(reprDigest, value)
+	}
+	if r.trailer {
+		return nil
+	}
+	r.Header().Set(contentLength, strconv.Itoa(len(r.body)))
+	r.wroteHeader = true
+	r.ResponseWriter.WriteHeader(r.status)
+	body := r.body
+	r.body = nil
+	if len(body) == 0 {
+		return nil
+	}
+	if _, err := r.write(body); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Unwrap returns the underlying writer.
+func (r *digestWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// NewDigestMiddleware checks Content-Digest and Repr-Digest (RFC 9530) of requests and
+// sets Content-Digest of responses. Algorithms of response digests are selected by
+// Want-Content-Digest header or WithDigestAlgorithms option.
+// Requests with incorrect digests are rejected with 400 status, requests with body
+// larger than WithDigestMaxSize limit are rejected with 413 status.
+//
+// Digests are calculated for data on the wire, so the middleware must wrap
+// NewCompressMiddleware (GzipMiddleware): DigestMiddleware(GzipMiddleware(handler)).
+// Repr-Digest of full responses is equal to Content-Digest, it is not sent for partial content.
+func NewDigestMiddleware(logger *zap.SugaredLogger, opts ...DigestOption) func(h http.Handler) http.Handler {
+	cfg := digestConfig{algorithms: []string{DigestSHA256}, maxSize: defaultMaxDecompressedSize}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(contentDigest) != "" || r.Header.Get(reprDigest) != "" || cfg.required {
+				data, err := readRequestBody(r, cfg.maxSize)
+				if err != nil {
+					w.WriteHeader(readErrorStatus(err))
+					logger.Warnf(getError(ReadBodyError, err).Error())
+					return
+				}
+				if err = r.Body.Close(); err != nil {
+					logger.Warnf(getError(CloseBodyError, err).Error())
+					return
+				}
+				if err = cfg.check(r, data); err != nil {
+					w.Header().Set(wantContentDigest, cfg.wantDigest())
+					w.WriteHeader(http.StatusBadRequest)
+					logger.Warnf("digest checker error: %v", err)
+					return
+				}
+				r = setBody(r, data)
+			}
+			dw := cfg.newDigestWriter(w, r)
+			next.ServeHTTP(wrapWriter(dw), r)
+			if err := dw.Close(); err != nil {
+				logger.Warnf("digest writer error: %v", err)
+			}
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,199 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestContentDigest(t *testing.T) {
+	value, err := ContentDigest([]byte(`{"hello": "world"}`), DigestSHA512)
+	assert.NoError(t, err)
+	// Example of RFC 9530 appendix D.1.
+	assert.Equal(t, "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:", value)
+	_, err = ContentDigest(nil, "md5")
+	assert.ErrorIs(t, err, ErrDigestUnsupported)
+}
+
+func Test_verifyDigest(t *testing.T) {
+	data := []byte(`{"hello": "world"}`)
+	tests := []struct {
+		name  string
+		value string
+		err   error
+	}{
+		{name: "SHA-256", value: "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:"},
+		{name: "Unsupported ignored", value: "md5=:AAAA:, sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:"},
+		{name: "Mismatch", value: "sha-256=:AAAA:", err: ErrDigestMismatch},
+		{name: "Unsupported", value: "md5=:AAAA:", err: ErrDigestUnsupported},
+		{name: "Not byte sequence", value: "sha-256=abc", err: ErrDigestMismatch},
+		{name: "Malformed", value: "sha-256=:AAAA", err: ErrStructuredField},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyDigest(data, tt.value)
+			if tt.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func Test_digestConfig_preferredDigest(t *testing.T) {
+	cfg := digestConfig{algorithms: []string{DigestSHA256}}
+	assert.Equal(t, []string{DigestSHA256}, cfg.preferredDigest(""))
+	assert.Equal(t, []string{DigestSHA512}, cfg.preferredDigest("sha-256=3, sha-512=10"))
+	assert.Equal(t, []string{DigestSHA256}, cfg.preferredDigest("sha-512=0, md5=10"))
+	assert.Equal(t, []string{DigestSHA256}, cfg.preferredDigest("sha-512=("))
+}
+
+func TestNewDigestMiddleware(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	data := strings.Repeat(`{"value":1}`, 200)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if !assert.NoError(t, err) {
+			return
+		}
+		w.Header().Set(contentType, applicationJSON)
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(body)
+		assert.NoError(t, err)
+	}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, err := zw.Write([]byte(data))
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	wireDigest, err := ContentDigest(compressed.Bytes())
+	assert.NoError(t, err)
+	plainDigest, err := ContentDigest([]byte(data))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		opts     []DigestOption
+		body     []byte
+		headers  map[string]string
+		status   int
+		encoding string
+		repr     bool
+		alg      string
+	}{
+		{name: "Without digest", body: []byte(data), status: http.StatusOK, alg: DigestSHA256},
+		{
+			name:    "Plain body",
+			body:    []byte(data),
+			headers: map[string]string{contentDigest: plainDigest, wantContentDigest: "sha-512=5"},
+			status:  http.StatusOK,
+			alg:     DigestSHA512,
+		},
+		{
+			name: "Compressed body",
+			body: compressed.Bytes(),
+			headers: map[string]string{
+				contentDigest:   wireDigest,
+				contentEncoding: gzipString,
+				acceptEncoding:  gzipString,
+			},
+			status:   http.StatusOK,
+			encoding: gzipString,
+			alg:      DigestSHA256,
+		},
+		{
+			name:    "Digest of decompressed body",
+			body:    compressed.Bytes(),
+			headers: map[string]string{contentDigest: plainDigest, contentEncoding: gzipString},
+			status:  http.StatusBadRequest,
+		},
+		{name: "Required", opts: []DigestOption{WithRequiredDigest()}, body: []byte(data), status: http.StatusBadRequest},
+		{
+			name:    "Too large body",
+			opts:    []DigestOption{WithDigestMaxSize(int64(len(data)) - 1)},
+			body:    []byte(data),
+			headers: map[string]string{contentDigest: plainDigest},
+			status:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:    "Size limit disabled",
+			opts:    []DigestOption{WithDigestMaxSize(0)},
+			body:    []byte(data),
+			headers: map[string]string{contentDigest: plainDigest},
+			status:  http.StatusOK,
+			alg:     DigestSHA256,
+		},
+		{
+			name:    "Repr digest",
+			opts:    []DigestOption{WithDigestTrailer()},
+			body:    []byte(data),
+			headers: map[string]string{wantReprDigest: "sha-256=1"},
+			status:  http.StatusOK,
+			repr:    true,
+			alg:     DigestSHA256,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewDigestMiddleware(logger, tt.opts...)(GzipMiddleware(logger)(http.HandlerFunc(handler)))
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body))
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, r)
+			resp := w.Result()
+			defer resp.Body.Close() //nolint:errcheck //<-test
+			assert.Equal(t, tt.status, resp.StatusCode)
+			if tt.status == http.StatusBadRequest {
+				assert.Equal(t, "sha-256=10", resp.Header.Get(wantContentDigest))
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.encoding, resp.Header.Get(contentEncoding))
+			header := resp.Header
+			if len(resp.Trailer) > 0 {
+				header = resp.Trailer
+			}
+			want, err := ContentDigest(body, tt.alg)
+			assert.NoError(t, err)
+			assert.Equal(t, want, header.Get(contentDigest), "digest of bytes on the wire")
+			if tt.repr {
+				assert.Equal(t, want, header.Get(reprDigest))
+			} else {
+				assert.Empty(t, header.Get(reprDigest))
+			}
+		})
+	}
+}
+
+func TestNewDigestMiddleware_partial(t *testing.T) {
+	data := strings.Repeat("0123456789", 100)
+	handler := NewDigestMiddleware(zap.NewNop().Sugar(), WithReprDigest())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "data.txt", time.Time{}, strings.NewReader(data))
+		}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Range", "bytes=10-19")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, data[10:20], w.Body.String())
+	sum := sha256.Sum256([]byte(data[10:20]))
+	assert.Equal(t, "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":", w.Header().Get(contentDigest))
+	assert.Empty(t, w.Header().Get(reprDigest), "repr digest of partial content")
+}
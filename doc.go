@@ -2,5 +2,7 @@
 // log write
 // gzip support
 // hash check
+// message signatures (RFC 9421)
+// content digests (RFC 9530)
 // decript messages.
 package middlewares
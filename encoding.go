@@ -0,0 +1,245 @@
+package middlewares
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	deflateString  = "deflate"
+	identityString = "identity"
+)
+
+// Compressor is a compression stream created by Encoder.
+type Compressor interface {
+	io.WriteCloser
+	// Flush writes buffered data to the underlying writer.
+	Flush() error
+	// Reset discards the state and starts a new stream to w, so compressors may be pooled.
+	Reset(w io.Writer)
+}
+
+// Encoder implements HTTP content coding (e.g. gzip, deflate, br, zstd).
+// Encoders are registered by RegisterEncoder and selected by name in
+// Content-Encoding and Accept-Encoding headers.
+// Encoder may implement Extension() string method, which returns extension of
+// precompressed sidecar files, see NewStaticHandler.
+type Encoder interface {
+	// Encoding returns content coding name.
+	Encoding() string
+	// NewCompressor creates compression stream to w.
+	NewCompressor(w io.Writer) (Compressor, error)
+	// NewDecompressor creates decompression stream from r.
+	NewDecompressor(r io.Reader) (io.ReadCloser, error)
+}
+
+// Internal types.
+type (
+	// Encoder with pool of compressors.
+	encoderPool struct {
+		Encoder
+		pool sync.Pool
+	}
+	// Content coding from Accept-Encoding header with quality value.
+	acceptCoding struct {
+		name string
+		q    float64
+	}
+	// Built-in gzip encoder.
+	gzipEncoder struct{}
+	// Built-in deflate (zlib format) encoder.
+	deflateEncoder struct{}
+)
+
+// Sidecar file extensions of built-in and well-known encoders.
+// Other encoders use "." + encoding name.
+var encoderExtensions = map[string]string{
+	gzipString:    ".gz",
+	deflateString: ".zz",
+	"zstd":        ".zst",
+}
+
+// Registry of encoders.
+var encoders = struct {
+	items map[string]*encoderPool
+	mutex sync.RWMutex
+}{items: make(map[string]*encoderPool)}
+
+func init() {
+	RegisterEncoder(gzipEncoder{})
+	RegisterEncoder(deflateEncoder{})
+}
+
+// RegisterEncoder adds encoder to registry. Encoder with the same name is replaced.
+// Registered encoders are used by the middlewares when they are listed in WithEncoders option.
+func RegisterEncoder(e Encoder) {
+	encoders.mutex.Lock()
+	defer encoders.mutex.Unlock()
+	encoders.items[strings.ToLower(e.Encoding())] = &encoderPool{Encoder: e}
+}
+
+// lookupEncoder returns registered encoder by name.
+func lookupEncoder(name string) (*encoderPool, bool) {
+	encoders.mutex.RLock()
+	defer encoders.mutex.RUnlock()
+	e, ok := encoders.items[strings.ToLower(name)]
+	return e, ok
+}
+
+// registeredEncodings returns sorted names of registered encoders.
+func registeredEncodings() []string {
+	encoders.mutex.RLock()
+	defer encoders.mutex.RUnlock()
+	names := make([]string, 0, len(encoders.items))
+	for name := range encoders.items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// encoderExtension returns extension of precompressed files for encoder.
+func encoderExtension(e Encoder) string {
+	if ext, ok := e.(interface{ Extension() string }); ok {
+		return ext.Extension()
+	}
+	name := strings.ToLower(e.Encoding())
+	if ext, ok := encoderExtensions[name]; ok {
+		return ext
+	}
+	return "." + name
+}
+
+// get returns pooled or new compressor to w.
+func (p *encoderPool) get(w io.Writer) (Compressor, error) {
+	if c, ok := p.pool.Get().(Compressor); ok {
+		c.Reset(w)
+		return c, nil
+	}
+	c, err := p.NewCompressor(w)
+	if err != nil {
+		return nil, fmt.Errorf("create %s compressor error: %w", p.Encoding(), err)
+	}
+	return c, nil
+}
+
+// put returns compressor to pool.
+func (p *encoderPool) put(c Compressor) {
+	c.Reset(io.Discard)
+	p.pool.Put(c)
+}
+
+// Encoding returns "gzip".
+func (gzipEncoder) Encoding() string {
+	return gzipString
+}
+
+// NewCompressor creates gzip writer.
+func (gzipEncoder) NewCompressor(w io.Writer) (Compressor, error) {
+	return gzip.NewWriter(w), nil
+}
+
+// NewDecompressor creates gzip reader.
+func (gzipEncoder) NewDecompressor(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r) //nolint:wrapcheck //<-wrapped by caller
+}
+
+// Encoding returns "deflate".
+func (deflateEncoder) Encoding() string {
+	return deflateString
+}
+
+// NewCompressor creates zlib writer.
+func (deflateEncoder) NewCompressor(w io.Writer) (Compressor, error) {
+	return zlib.NewWriter(w), nil
+}
+
+// NewDecompressor creates zlib reader.
+func (deflateEncoder) NewDecompressor(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r) //nolint:wrapcheck //<-wrapped by caller
+}
+
+// Check that built-in compressors implement Compressor.
+var (
+	_ Compressor = (*gzip.Writer)(nil)
+	_ Compressor = (*zlib.Writer)(nil)
+)
+
+// parseAcceptEncoding parses Accept-Encoding header value.
+// Codings with incorrect quality value are ignored.
+func parseAcceptEncoding(value string) []acceptCoding {
+	codings := make([]acceptCoding, 0)
+	for _, item := range strings.Split(value, ",") {
+		params := strings.Split(item, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name == "" {
+			continue
+		}
+		coding := acceptCoding{name: name, q: 1}
+		for _, param := range params[1:] {
+			key, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || !strings.EqualFold(strings.TrimSpace(key), "q") {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+			if err != nil || q < 0 || q > 1 {
+				coding.q = -1
+				break
+			}
+			coding.q = q
+		}
+		if coding.q >= 0 {
+			codings = append(codings, coding)
+		}
+	}
+	return codings
+}
+
+// negotiateEncoding selects content coding for response according to
+// Accept-Encoding header and server preference order.
+// Returns empty string when identity must be used.
+func negotiateEncoding(header string, preference []string) string {
+	if header == "" {
+		return ""
+	}
+	codings := parseAcceptEncoding(header)
+	quality := func(name string) float64 {
+		wildcard := float64(-1)
+		for _, c := range codings {
+			if c.name == name {
+				return c.q
+			}
+			if c.name == "*" {
+				wildcard = c.q
+			}
+		}
+		if wildcard < 0 {
+			return 0
+		}
+		return wildcard
+	}
+	candidates := make([]acceptCoding, 0, len(preference))
+	for _, name := range preference {
+		if q := quality(name); q > 0 {
+			candidates = append(candidates, acceptCoding{name: name, q: q})
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	for _, c := range codings {
+		if c.name == identityString && c.q > candidates[0].q {
+			return ""
+		}
+	}
+	return candidates[0].name
+}
@@ -0,0 +1,121 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// Raw deflate encoder for tests.
+type rawDeflateEncoder struct{}
+
+func (rawDeflateEncoder) Encoding() string { return "x-raw-deflate" }
+
+func (rawDeflateEncoder) NewCompressor(w io.Writer) (Compressor, error) {
+	return flate.NewWriter(w, flate.BestSpeed) //nolint:wrapcheck //<-test
+}
+
+func (rawDeflateEncoder) NewDecompressor(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+func Test_negotiateEncoding(t *testing.T) {
+	preference := []string{gzipString, deflateString}
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{name: "Empty header", header: "", want: ""},
+		{name: "Only gzip", header: "gzip", want: gzipString},
+		{name: "Server preference", header: "deflate, gzip", want: gzipString},
+		{name: "Quality values", header: "gzip;q=0.5, deflate;q=0.8", want: deflateString},
+		{name: "Gzip disabled", header: "gzip;q=0, deflate", want: deflateString},
+		{name: "Wildcard", header: "*", want: gzipString},
+		{name: "Wildcard with disabled", header: "*;q=0.5, gzip;q=0", want: deflateString},
+		{name: "Identity preferred", header: "gzip;q=0.2, identity", want: ""},
+		{name: "Unknown codings", header: "br, zstd", want: ""},
+		{name: "Incorrect quality", header: "gzip;q=2, deflate;q=0.1", want: deflateString},
+		{name: "Case and spaces", header: " GZIP ; Q=0.9 ", want: gzipString},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, negotiateEncoding(tt.header, preference))
+		})
+	}
+}
+
+func TestNewCompressMiddleware(t *testing.T) {
+	data := strings.Repeat("compressed data ", 100)
+	handler := NewCompressMiddleware(zap.NewNop().Sugar())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(contentType, applicationJSON)
+			w.WriteHeader(http.StatusOK)
+			_, err := w.Write([]byte(data))
+			assert.NoError(t, err)
+		}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(acceptEncoding, "gzip;q=0.1, deflate")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, deflateString, w.Header().Get(contentEncoding))
+	reader, err := zlib.NewReader(w.Body)
+	assert.NoError(t, err, "deflate reader error")
+	body, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, data, string(body))
+
+	r.Header.Set(acceptEncoding, "gzip;q=0, deflate;q=0")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Empty(t, w.Header().Get(contentEncoding), "disabled coding used")
+	assert.Equal(t, data, w.Body.String())
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	encoder := rawDeflateEncoder{}
+	RegisterEncoder(encoder)
+	var buf bytes.Buffer
+	c, err := encoder.NewCompressor(&buf)
+	assert.NoError(t, err)
+	_, err = c.Write([]byte("request data"))
+	assert.NoError(t, err)
+	assert.NoError(t, c.Close())
+
+	var body []byte
+	handler := NewCompressMiddleware(zap.NewNop().Sugar(), WithEncoders(encoder.Encoding()), WithMinSize(0))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err = io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			w.Header().Set(contentType, textHTML)
+			w.WriteHeader(http.StatusOK)
+			_, err = w.Write([]byte("response data"))
+			assert.NoError(t, err)
+		}))
+	r := httptest.NewRequest(http.MethodPost, "/", &buf)
+	r.Header.Set(contentEncoding, encoder.Encoding())
+	r.Header.Set(acceptEncoding, "gzip, x-raw-deflate;q=0.5")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []byte("request data"), body)
+	assert.Equal(t, encoder.Encoding(), w.Header().Get(contentEncoding))
+	resp, err := io.ReadAll(flate.NewReader(w.Body))
+	assert.NoError(t, err)
+	assert.Equal(t, "response data", string(resp))
+
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+	r.Header.Set(contentEncoding, "unknown")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code, "unknown coding accepted")
+}
@@ -1,14 +1,22 @@
 package middlewares
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 
+	"github.com/gostuding/middlewares/keys"
 	"github.com/gostuding/middlewares/mocks"
 	"go.uber.org/zap"
 )
@@ -43,6 +51,26 @@ func ExampleNewHashWriter() {
 	// Hash: 5031fe3d989c6d1537a013fa6e739da23463fdaec3b70137d828e36ace221bd0
 }
 
+func ExampleNewBufferedHashWriter() {
+	r := mocks.NewWMock()
+	w := NewBufferedHashWriter(r, []byte("key"))
+	for _, chunk := range []string{"da", "ta"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			fmt.Printf("write error: %v", err)
+			return
+		}
+	}
+	// Close sends hash summ of the full body and buffered body.
+	if err := w.Close(); err != nil {
+		fmt.Printf("close error: %v", err)
+		return
+	}
+	fmt.Printf("Hash: %s, body: %s", w.Header().Get(hashVarName), string(r.Body))
+
+	// Output:
+	// Hash: 5031fe3d989c6d1537a013fa6e739da23463fdaec3b70137d828e36ace221bd0, body: data
+}
+
 func ExampleNewGzipWriter() {
 	data := []byte(strings.Repeat("data", 1000))
 	r := mocks.NewWMock()
@@ -59,10 +87,52 @@ func ExampleNewGzipWriter() {
 		fmt.Printf("write error: %v", err)
 		return
 	}
-	fmt.Printf("Body length: %d, data legth: %d", len(r.Body), len(data))
+	compressed := len(r.Body)
+	reader, err := NewGzipReader(r)
+	if err != nil {
+		fmt.Printf("create reader error: %v", err)
+		return
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		fmt.Printf("read error: %v", err)
+		return
+	}
+	fmt.Printf("Compressed: %t, equal: %t", compressed < len(data), bytes.Equal(body, data))
 
 	// Output:
-	// Body length: 48, data legth: 4000
+	// Compressed: true, equal: true
+}
+
+func ExampleNewStreamingGzipWriter() {
+	r := mocks.NewWMock()
+	w := NewStreamingGzipWriter(r, zap.NewNop().Sugar())
+	w.Header().Set(contentType, applicationJSON)
+	for i := 0; i < 3; i++ {
+		if _, err := w.Write([]byte("data")); err != nil {
+			fmt.Printf("write error: %v", err)
+			return
+		}
+	}
+	// Close finishes gzip stream.
+	if err := w.Close(); err != nil {
+		fmt.Printf("close error: %v", err)
+		return
+	}
+	reader, err := NewGzipReader(r)
+	if err != nil {
+		fmt.Printf("create reader error: %v", err)
+		return
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		fmt.Printf("read error: %v", err)
+		return
+	}
+	fmt.Printf("Encoding: %s, data: %s", w.Header().Get(contentEncoding), string(body))
+
+	// Output:
+	// Encoding: gzip, data: datadatadata
 }
 
 func ExampleNewGzipReader() {
@@ -167,3 +237,67 @@ func ExampleSubNetCheckMiddleware() {
 	// Output:
 	// subnet string: 127.0.0.0/24
 }
+
+func ExampleNewDecriptMiddleware() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		fmt.Printf("create key errror: %v", err)
+		return
+	}
+	ring := keys.NewRing[crypto.PrivateKey]()
+	ring.Add("key-1", key)
+	handler := NewDecriptMiddleware(zap.NewNop().Sugar(), WithJWE(ring.Lookup))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				fmt.Printf("read body error: %v", err)
+				return
+			}
+			fmt.Printf("%s: %s", r.Header.Get("Content-Type"), body)
+		}))
+
+	// JWE with RSA-OAEP-256 and A256GCM, as created by clients.
+	cek := make([]byte, 32)
+	if _, err = rand.Read(cek); err != nil {
+		fmt.Printf("create key errror: %v", err)
+		return
+	}
+	encKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &key.PublicKey, cek, nil)
+	if err != nil {
+		fmt.Printf("encrypt key errror: %v", err)
+		return
+	}
+	protected := base64.RawURLEncoding.EncodeToString(
+		[]byte(`{"alg":"RSA-OAEP-256","enc":"A256GCM","kid":"key-1","cty":"application/json"}`))
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		fmt.Printf("create cipher errror: %v", err)
+		return
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		fmt.Printf("create cipher errror: %v", err)
+		return
+	}
+	iv := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(iv); err != nil {
+		fmt.Printf("create iv errror: %v", err)
+		return
+	}
+	sealed := aead.Seal(nil, iv, []byte(`{"id":"metric"}`), []byte(protected))
+	tag := len(sealed) - aead.Overhead()
+	msg := strings.Join([]string{
+		protected,
+		base64.RawURLEncoding.EncodeToString(encKey),
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(sealed[:tag]),
+		base64.RawURLEncoding.EncodeToString(sealed[tag:]),
+	}, ".")
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(msg))
+	r.Header.Set("Content-Type", "application/jose")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	// Output:
+	// application/json: {"id":"metric"}
+}
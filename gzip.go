@@ -1,85 +1,573 @@
 package middlewares
 
 import (
-	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"go.uber.org/zap"
 )
 
 // Internal types.
 type (
-	// Struct for write gzip data in response.
+	// Struct for write compressed data in response.
+	// One compression stream is opened lazily on the first compressed write
+	// and finished by Close.
 	myGzipWriter struct {
 		http.ResponseWriter
-		logger    *zap.SugaredLogger
-		isWriting bool
+		logger      *zap.SugaredLogger
+		cfg         *compressConfig
+		encoder     *encoderPool
+		compressor  Compressor
+		output      *countWriter
+		stats       *CompressionStats
+		buf         []byte
+		written     int64
+		elapsed     time.Duration
+		status      int
+		wroteHeader bool
+		buffering   bool
+		compress    bool
 	}
-	// Struct for read gzip data from request.
+	// Struct for write gzip data in response by NewGzipWriter.
+	// Every Write is compressed as a complete gzip member.
+	gzipMemberWriter struct {
+		http.ResponseWriter
+		logger *zap.SugaredLogger
+	}
+	// Struct for read compressed data from request.
 	gzipReader struct {
-		r    io.ReadCloser
-		gzip *gzip.Reader
+		r       io.ReadCloser
+		decoder io.ReadCloser
+		limits  *decompressLimits
+		read    int64
+	}
+	// Counts bytes read from reader.
+	countReader struct {
+		r io.Reader
+		n int64
+	}
+	// Limits of decompressed request body.
+	// Compressed size is counted on the original body, so nested
+	// content codings are limited as a whole.
+	decompressLimits struct {
+		input    *countReader
+		maxSize  int64
+		maxRatio int64
+		exceeded bool
+	}
+	// Response writer which sends 413 status instead of handler response,
+	// when request body read by handler exceeds decompression limits.
+	limitWriter struct {
+		http.ResponseWriter
+		limits      *decompressLimits
+		wroteHeader bool
+		rejected    bool
+	}
+	// CompressMiddleware settings.
+	compressConfig struct {
+		encoders []string
+		types    []string
+		hook     func(r *http.Request, stats *CompressionStats)
+		counters *CompressionCounters
+		minSize  int
+		maxSize  int64
+		maxRatio int64
 	}
 )
 
-// NewGzipWriter creates new writer.
-func NewGzipWriter(r http.ResponseWriter, logger *zap.SugaredLogger) *myGzipWriter {
-	return &myGzipWriter{ResponseWriter: r, isWriting: false, logger: logger}
+// ErrUnsupportedEncoding is returned when request Content-Encoding has not registered coding.
+// Middlewares of the package respond with 415 status on the error (RFC 9110, section 15.5.16).
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// DecompressLimitError is returned by request body reader when decompressed data
+// exceeds limits set by WithMaxDecompressedSize or WithMaxRatio.
+// Middlewares of the package respond with 413 status on the error. NewCompressMiddleware
+// replaces response of handler which got the error by 413 status response too.
+type DecompressLimitError struct {
+	Limit int64 // Exceeded limit value.
+	Ratio bool  // Compression ratio limit is exceeded, otherwise size limit.
 }
 
-// Write data in response by gzip writer.
+// Error returns error description.
+func (e *DecompressLimitError) Error() string {
+	if e.Ratio {
+		return fmt.Sprintf("decompressed body exceeds compression ratio limit %d", e.Limit)
+	}
+	return fmt.Sprintf("decompressed body exceeds size limit %d bytes", e.Limit)
+}
+
+const (
+	contentLength  = "Content-Length"
+	contentRange   = "Content-Range"
+	etagHeader     = "ETag"
+	varyHeader     = "Vary"
+	defaultMinSize = 1024
+	// Default limit of decompressed request body size.
+	defaultMaxDecompressedSize = 32 << 20
+	// Compression ratio is checked when decompressed data exceeds the size,
+	// so small well-compressible bodies are not rejected.
+	ratioMinSize = 64 << 10
+)
+
+// Default compressible content types.
+var defaultCompressTypes = []string{
+	"text/*",
+	applicationJSON,
+	"application/*+json",
+	"application/javascript",
+	"application/xml",
+	"application/*+xml",
+	"image/svg+xml",
+}
+
+// CompressOption sets CompressMiddleware settings.
+type CompressOption func(*compressConfig)
+
+// WithEncoders sets names of encoders for responses in server preference order.
+// Encoders must be registered by RegisterEncoder. Default is "gzip", "deflate".
+func WithEncoders(names ...string) CompressOption {
+	return func(c *compressConfig) {
+		c.encoders = names
+	}
+}
+
+// WithContentTypes sets compressible response content types.
+// Patterns are matched with media type without parameters, so "application/json"
+// matches "application/json; charset=utf-8". Wildcards are supported:
+// "text/*", "application/*+json". Default is text, JSON, JavaScript, XML and SVG types.
+func WithContentTypes(types ...string) CompressOption {
+	return func(c *compressConfig) {
+		c.types = make([]string, 0, len(types))
+		for _, item := range types {
+			c.types = append(c.types, strings.ToLower(strings.TrimSpace(item)))
+		}
+	}
+}
+
+// WithMinSize sets minimum response size for compression.
+// Response data is buffered until the size is reached, so smaller responses
+// are sent uncompressed. Content-Length header is used when handler sets it.
+// Zero size disables buffering. Default is 1024 bytes.
+func WithMinSize(size int) CompressOption {
+	return func(c *compressConfig) {
+		c.minSize = size
+	}
+}
+
+// WithMaxDecompressedSize sets limit of decompressed request body size in bytes.
+// The limit is applied to all members of multi-member streams and to nested content codings.
+// Zero size disables the limit. Default is 32 MiB.
+func WithMaxDecompressedSize(size int64) CompressOption {
+	return func(c *compressConfig) {
+		c.maxSize = size
+	}
+}
+
+// WithMaxRatio sets limit of decompressed to compressed request body size ratio.
+// The ratio is checked when more than 64 KiB are decompressed.
+// Zero ratio disables the limit. Default is disabled.
+func WithMaxRatio(ratio int64) CompressOption {
+	return func(c *compressConfig) {
+		c.maxRatio = ratio
+	}
+}
+
+// newCompressConfig creates CompressMiddleware settings.
+func newCompressConfig(opts ...CompressOption) *compressConfig {
+	cfg := compressConfig{
+		encoders: []string{gzipString, deflateString},
+		types:    defaultCompressTypes,
+		minSize:  defaultMinSize,
+		maxSize:  defaultMaxDecompressedSize,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &cfg
+}
+
+// compressible checks that response with the content type may be compressed.
+func (c *compressConfig) compressible(value string) bool {
+	media := mediaType(value)
+	if media == "" {
+		return false
+	}
+	for _, pattern := range c.types {
+		if ok, err := path.Match(pattern, media); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// NewGzipWriter creates new writer. Response of 200 status with "application/json" or
+// "text/html" content type is compressed. Every Write is compressed as a complete
+// gzip member, so the writer does not need to be closed.
+func NewGzipWriter(r http.ResponseWriter, logger *zap.SugaredLogger) *gzipMemberWriter {
+	return &gzipMemberWriter{ResponseWriter: r, logger: logger}
+}
+
+// NewStreamingGzipWriter creates new writer, which compresses responses of any size
+// with compressible content type (see WithContentTypes) as one gzip stream.
+// Close must be called after the last Write to finish the stream.
+func NewStreamingGzipWriter(r http.ResponseWriter, logger *zap.SugaredLogger) *myGzipWriter {
+	encoder, _ := lookupEncoder(gzipString) //nolint:errcheck //<-gzip is built-in
+	return newCompressWriter(r, logger, newCompressConfig(WithMinSize(0)), encoder)
+}
+
+// newCompressWriter creates writer for encoder.
+func newCompressWriter(
+	r http.ResponseWriter,
+	logger *zap.SugaredLogger,
+	cfg *compressConfig,
+	encoder *encoderPool,
+) *myGzipWriter {
+	return &myGzipWriter{ResponseWriter: r, logger: logger, cfg: cfg, encoder: encoder}
+}
+
+// Write data in response by compressor.
+// If Content-Type is not set by handler, it is detected by the first data block.
 func (r *myGzipWriter) Write(b []byte) (int, error) {
-	if !r.isWriting && r.Header().Get(contentEncoding) == gzipString {
-		r.isWriting = true
-		compressor := gzip.NewWriter(r)
-		size, err := compressor.Write(b)
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	if r.status != 0 && !r.wroteHeader && !r.buffering {
+		if r.Header().Get(contentType) == "" {
+			r.Header().Set(contentType, http.DetectContentType(b))
+		}
+		r.writeHeader(r.status)
+	}
+	if r.buffering {
+		r.buf = append(r.buf, b...)
+		if len(r.buf) < r.cfg.minSize {
+			return len(b), nil
+		}
+		if err := r.flushBuffer(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if r.compressor == nil && r.compress {
+		r.output = &countWriter{w: r.ResponseWriter}
+		compressor, err := r.encoder.get(r.output)
 		if err != nil {
-			return 0, fmt.Errorf("compress respons body error: %w", err)
+			return 0, err
 		}
-		if err = compressor.Close(); err != nil {
-			return 0, fmt.Errorf("compress close error: %w", err)
+		r.compressor = compressor
+	}
+	if r.compressor != nil {
+		start := time.Now()
+		size, err := r.compressor.Write(b)
+		r.elapsed += time.Since(start)
+		r.written += int64(size)
+		if err != nil {
+			return size, fmt.Errorf("compress respons body error: %w", err)
 		}
-		r.isWriting = false
 		return size, nil
 	}
-	return r.ResponseWriter.Write(b) //nolint:wrapcheck //<-senselessly
+	size, err := r.ResponseWriter.Write(b)
+	r.written += int64(size)
+	return size, err //nolint:wrapcheck //<-senselessly
 }
 
 // WriteHeader checks Content-Type and sets Content-Encoding data.
+// When Content-Type is not set, headers are sent with the first data block.
+// Informational statuses are sent as is.
 func (r *myGzipWriter) WriteHeader(statusCode int) {
-	contentType := r.Header().Get(contentType) == applicationJSON || r.Header().Get(contentType) == textHTML
-	if statusCode == http.StatusOK && contentType {
-		r.Header().Set(contentEncoding, gzipString)
+	if r.wroteHeader || r.status != 0 {
+		return
+	}
+	if statusCode >= http.StatusContinue && statusCode < http.StatusOK {
+		r.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if bodyAllowed(statusCode) && r.Header().Get(contentType) == "" {
+		r.status = statusCode
+		return
+	}
+	r.writeHeader(statusCode)
+}
+
+// bodyAllowed checks that response with the status may have compressible body.
+func bodyAllowed(statusCode int) bool {
+	switch statusCode {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return false
+	default:
+		return true
+	}
+}
+
+// compressible checks that response may be compressed.
+// Responses encoded by handler and partial responses are not compressed.
+func (r *myGzipWriter) compressible(statusCode int) bool {
+	if !bodyAllowed(statusCode) || r.Header().Get(contentRange) != "" {
+		return false
+	}
+	if r.encodedByHandler() {
+		return false
+	}
+	return r.cfg.compressible(r.Header().Get(contentType))
+}
+
+// encodedByHandler checks that handler has set Content-Encoding of response.
+func (r *myGzipWriter) encodedByHandler() bool {
+	encoding := r.Header().Get(contentEncoding)
+	return encoding != "" && !strings.EqualFold(encoding, identityString)
+}
+
+// writeHeader sets Content-Encoding for compressible content and sends headers.
+// If response size is unknown, headers are postponed until minimum size is buffered.
+// Vary header is set for compressible content type whether it is compressed or not,
+// so caches don't serve identity response to clients accepting compression and vice versa.
+// Writer without encoder never compresses.
+func (r *myGzipWriter) writeHeader(statusCode int) {
+	r.status = statusCode
+	if r.cfg.compressible(r.Header().Get(contentType)) && !r.encodedByHandler() {
+		addVary(r.Header(), acceptEncoding)
+	}
+	if r.encoder != nil && r.compressible(statusCode) {
+		if r.cfg.minSize <= 0 {
+			r.setEncoding()
+		} else if size, err := strconv.Atoi(r.Header().Get(contentLength)); err != nil {
+			r.buffering = true
+			r.buf = make([]byte, 0, r.cfg.minSize)
+			return
+		} else if size >= r.cfg.minSize {
+			r.setEncoding()
+		}
 	}
+	r.wroteHeader = true
 	r.ResponseWriter.WriteHeader(statusCode)
 }
 
+// setEncoding sets Content-Encoding header.
+// Content-Length of uncompressed data is removed and strong ETag is weakened,
+// because compressed body is not byte-for-byte equal to the original one.
+func (r *myGzipWriter) setEncoding() {
+	r.compress = true
+	r.Header().Set(contentEncoding, r.encoder.Encoding())
+	r.Header().Del(contentLength)
+	if etag := r.Header().Get(etagHeader); etag != "" && !strings.HasPrefix(etag, "W/") {
+		r.Header().Set(etagHeader, "W/"+etag)
+	}
+}
+
+// addVary adds field name to Vary header if it is not listed yet.
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values(varyHeader) {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "*" || strings.EqualFold(item, name) {
+				return
+			}
+		}
+	}
+	header.Add(varyHeader, name)
+}
+
+// flushBuffer sends postponed headers and buffered data.
+func (r *myGzipWriter) flushBuffer(compress bool) error {
+	r.buffering = false
+	if compress {
+		r.setEncoding()
+	}
+	r.wroteHeader = true
+	r.ResponseWriter.WriteHeader(r.status)
+	buf := r.buf
+	r.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if _, err := r.Write(buf); err != nil {
+		return fmt.Errorf("write buffered data error: %w", err)
+	}
+	return nil
+}
+
+// commitHeader sends postponed headers and data, buffered data is sent uncompressed.
+func (r *myGzipWriter) commitHeader() error {
+	if r.buffering {
+		return r.flushBuffer(false)
+	}
+	if r.status != 0 && !r.wroteHeader {
+		r.ResponseWriter.WriteHeader(r.status)
+		r.wroteHeader = true
+	}
+	return nil
+}
+
 // Header returns response headers map.
 func (r *myGzipWriter) Header() http.Header {
 	return r.ResponseWriter.Header()
 }
 
-// NewGzipReader creates new gzip reader.
+// Unwrap returns the underlying writer.
+func (r *myGzipWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Flush writes compressed data to client.
+func (r *myGzipWriter) Flush() {
+	if err := r.commitHeader(); err != nil {
+		r.logger.Warnf("flush error: %v", err)
+		return
+	}
+	if r.compressor != nil {
+		start := time.Now()
+		err := r.compressor.Flush()
+		r.elapsed += time.Since(start)
+		if err != nil {
+			r.logger.Warnf("compressor flush error: %v", err)
+			return
+		}
+	}
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes compression stream and returns compressor to pool.
+// Stats of uncompressed response have empty encoding and equal sizes.
+func (r *myGzipWriter) Close() error {
+	if err := r.commitHeader(); err != nil {
+		return err
+	}
+	if r.compressor == nil {
+		if r.stats != nil {
+			*r.stats = CompressionStats{
+				ContentType:  mediaType(r.Header().Get(contentType)),
+				Uncompressed: r.written,
+				Compressed:   r.written,
+			}
+		}
+		return nil
+	}
+	start := time.Now()
+	err := r.compressor.Close()
+	r.elapsed += time.Since(start)
+	r.encoder.put(r.compressor)
+	r.compressor = nil
+	if r.stats != nil {
+		*r.stats = CompressionStats{
+			Encoding:     r.encoder.Encoding(),
+			ContentType:  mediaType(r.Header().Get(contentType)),
+			Uncompressed: r.written,
+			Compressed:   r.output.n,
+			Duration:     r.elapsed - r.output.duration,
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("compress close error: %w", err)
+	}
+	return nil
+}
+
+// Write data in response by gzip writer.
+func (r *gzipMemberWriter) Write(b []byte) (int, error) {
+	if r.Header().Get(contentEncoding) != gzipString {
+		return r.ResponseWriter.Write(b) //nolint:wrapcheck //<-senselessly
+	}
+	encoder, _ := lookupEncoder(gzipString) //nolint:errcheck //<-gzip is built-in
+	compressor, err := encoder.get(r.ResponseWriter)
+	if err != nil {
+		return 0, err
+	}
+	defer encoder.put(compressor)
+	size, err := compressor.Write(b)
+	if err != nil {
+		return 0, fmt.Errorf("compress respons body error: %w", err)
+	}
+	if err = compressor.Close(); err != nil {
+		return 0, fmt.Errorf("compress close error: %w", err)
+	}
+	return size, nil
+}
+
+// WriteHeader checks Content-Type and sets Content-Encoding data.
+func (r *gzipMemberWriter) WriteHeader(statusCode int) {
+	ctype := r.Header().Get(contentType)
+	if statusCode == http.StatusOK && (ctype == applicationJSON || ctype == textHTML) {
+		r.Header().Set(contentEncoding, gzipString)
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Header returns response headers map.
+func (r *gzipMemberWriter) Header() http.Header {
+	return r.ResponseWriter.Header()
+}
+
+// Unwrap returns the underlying writer.
+func (r *gzipMemberWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// NewGzipReader creates new gzip reader without decompression limits.
 func NewGzipReader(r io.ReadCloser) (*gzipReader, error) {
-	reader, err := gzip.NewReader(r)
+	encoder, _ := lookupEncoder(gzipString) //nolint:errcheck //<-gzip is built-in
+	return newDecompressReader(r, r, encoder, nil)
+}
+
+// newDecompressReader creates reader of src for encoder. Reader r is closed by Close.
+func newDecompressReader(
+	r io.ReadCloser,
+	src io.Reader,
+	encoder Encoder,
+	limits *decompressLimits,
+) (*gzipReader, error) {
+	reader, err := encoder.NewDecompressor(src)
 	if err != nil {
-		return nil, fmt.Errorf("new gzip reader create error: %w", err)
+		return nil, fmt.Errorf("new %s reader create error: %w", encoder.Encoding(), err)
+	}
+	return &gzipReader{r: r, decoder: reader, limits: limits}, nil
+}
+
+// Read counts read data.
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err //nolint:wrapcheck //<-senselessly
+}
+
+// check returns DecompressLimitError if decompressed size exceeds limits.
+func (l *decompressLimits) check(size int64) error {
+	if l.maxSize > 0 && size > l.maxSize {
+		return &DecompressLimitError{Limit: l.maxSize}
+	}
+	if l.maxRatio > 0 && size > ratioMinSize && size > l.input.n*l.maxRatio {
+		return &DecompressLimitError{Limit: l.maxRatio, Ratio: true}
 	}
-	return &gzipReader{r: r, gzip: reader}, nil
+	return nil
 }
 
-// Read and ungzip data.
-func (c gzipReader) Read(p []byte) (n int, err error) {
-	size, err := c.gzip.Read(p)
+// Read and decompress data.
+// Returns DecompressLimitError if decompressed data exceeds limits.
+func (c *gzipReader) Read(p []byte) (n int, err error) {
+	if c.limits != nil && c.limits.maxSize > 0 {
+		if rest := c.limits.maxSize - c.read + 1; int64(len(p)) > rest {
+			p = p[:rest]
+		}
+	}
+	size, err := c.decoder.Read(p)
+	if c.limits != nil {
+		c.read += int64(size)
+		if lerr := c.limits.check(c.read); lerr != nil {
+			c.limits.exceeded = true
+			return 0, lerr
+		}
+	}
 	if errors.Is(err, io.EOF) {
 		return size, err //nolint:wrapcheck //<-senselessly
 	}
 	if err != nil {
-		return 0, fmt.Errorf("gzip read error: %w", err)
+		return 0, fmt.Errorf("decompress read error: %w", err)
 	}
 	return size, nil
 }
@@ -89,8 +577,8 @@ func (c *gzipReader) Close() error {
 	if err := c.r.Close(); err != nil {
 		return fmt.Errorf("close request interface error: %w", err)
 	}
-	if err := c.gzip.Close(); err != nil {
-		return fmt.Errorf("gzip reader close error: %w", err)
+	if err := c.decoder.Close(); err != nil {
+		return fmt.Errorf("decompress reader close error: %w", err)
 	}
 	return nil
 }
@@ -98,24 +586,159 @@ func (c *gzipReader) Close() error {
 // GzipMiddleware usefull for gzip support enable in server.
 // Is using when request contains Content-Encoding: gzip in Headers.
 func GzipMiddleware(logger *zap.SugaredLogger) func(h http.Handler) http.Handler {
+	return NewCompressMiddleware(logger, WithEncoders(gzipString))
+}
+
+// NewCompressMiddleware decompresses request bodies with registered content codings
+// and compresses responses with the encoder negotiated by Accept-Encoding header.
+// Accept-Encoding quality values, "identity" and "*" are respected. Responses with
+// compressible content type get "Vary: Accept-Encoding" header, even if they are not compressed.
+// If decompressed request body exceeds limits, handler gets DecompressLimitError on read
+// and its response is replaced by 413 status response. Body buffered by NewBodyMiddleware
+// is decompressed before handler, and request exceeding limits is rejected with 413 status.
+func NewCompressMiddleware(logger *zap.SugaredLogger, opts ...CompressOption) func(h http.Handler) http.Handler {
+	cfg := newCompressConfig(opts...)
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
-			if strings.Contains(r.Header.Get(contentEncoding), gzipString) {
-				cr, err := NewGzipReader(r.Body)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
-					logger.Warnf(getError(GzipReaderError, err).Error())
+			_, buffered := requestBody(r)
+			if err := decompressBody(r, cfg); err != nil {
+				logger.Warnf(getError(GzipReaderError, err).Error())
+				if errors.Is(err, ErrUnsupportedEncoding) {
+					w.Header().Set(acceptEncoding, strings.Join(registeredEncodings(), ", "))
+					w.WriteHeader(http.StatusUnsupportedMediaType)
 					return
 				}
-				r.Body = cr
-				defer cr.Close() //nolint:errcheck //<-senselessly
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if reader, ok := r.Body.(*gzipReader); ok && reader.limits != nil {
+				if buffered {
+					data, err := io.ReadAll(reader)
+					if err != nil {
+						logger.Warnf(getError(GzipReaderError, err).Error())
+						w.WriteHeader(readErrorStatus(err))
+						return
+					}
+					r = setBody(r, data)
+				} else {
+					lw := &limitWriter{ResponseWriter: w, limits: reader.limits}
+					defer lw.finish()
+					w = wrapWriter(lw)
+				}
 			}
-			if strings.Contains(r.Header.Get(acceptEncoding), gzipString) {
-				next.ServeHTTP(NewGzipWriter(w, logger), r)
-			} else {
-				next.ServeHTTP(w, r)
+			encoder, ok := lookupEncoder(negotiateEncoding(r.Header.Get(acceptEncoding), cfg.encoders))
+			if !ok || r.Method == http.MethodHead {
+				encoder = nil
 			}
+			r, stats := cfg.withStats(r)
+			cw := newCompressWriter(w, logger, cfg, encoder)
+			cw.stats = stats
+			next.ServeHTTP(wrapWriter(cw), r)
+			if err := cw.Close(); err != nil {
+				logger.Warnf(err.Error())
+			}
+			cfg.report(r, stats)
 		}
 		return http.HandlerFunc(fn)
 	}
 }
+
+// decompressBody replaces request body by decompress reader according to Content-Encoding.
+func decompressBody(r *http.Request, cfg *compressConfig) error {
+	body, err := newBodyDecompressor(r.Body, r.Header.Get(contentEncoding), cfg)
+	if err != nil {
+		return err
+	}
+	r.Body = body
+	return nil
+}
+
+// newBodyDecompressor returns reader of body decoded according to Content-Encoding
+// header value with limits of cfg. Content codings are decoded in reverse order.
+func newBodyDecompressor(body io.ReadCloser, header string, cfg *compressConfig) (io.ReadCloser, error) {
+	if header == "" {
+		return body, nil
+	}
+	limits := &decompressLimits{input: &countReader{r: body}, maxSize: cfg.maxSize, maxRatio: cfg.maxRatio}
+	var src io.Reader = limits.input
+	codings := strings.Split(header, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		name := strings.TrimSpace(codings[i])
+		if name == "" || strings.EqualFold(name, identityString) {
+			continue
+		}
+		encoder, ok := lookupEncoder(name)
+		if !ok {
+			return nil, fmt.Errorf("%w '%s'", ErrUnsupportedEncoding, name)
+		}
+		reader, err := newDecompressReader(body, src, encoder, limits)
+		if err != nil {
+			return nil, err
+		}
+		body = reader
+		src = reader
+	}
+	return body, nil
+}
+
+// WriteHeader sends 413 status instead of the handler status if request body exceeds
+// decompression limits. Informational statuses are sent as is.
+func (w *limitWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	if statusCode >= http.StatusContinue && statusCode < http.StatusOK {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	w.wroteHeader = true
+	if !w.limits.exceeded {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	w.rejected = true
+	for _, name := range []string{contentEncoding, contentLength, contentRange, etagHeader} {
+		w.Header().Del(name)
+	}
+	w.Header().Set(contentType, "text/plain; charset=utf-8")
+	w.ResponseWriter.WriteHeader(http.StatusRequestEntityTooLarge)
+	w.ResponseWriter.Write([]byte(http.StatusText(http.StatusRequestEntityTooLarge))) //nolint:errcheck //<-response is rejected
+}
+
+// Write sends data of handler response. Data of rejected response is discarded.
+func (w *limitWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.rejected {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b) //nolint:wrapcheck //<-senselessly
+}
+
+// Unwrap returns the underlying writer.
+func (w *limitWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish sends 413 status if handler got limit error and did not respond.
+func (w *limitWriter) finish() {
+	if !w.wroteHeader && w.limits.exceeded {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	}
+}
+
+// readErrorStatus returns response status for request body read error.
+func readErrorStatus(err error) int {
+	var limitErr *DecompressLimitError
+	if errors.As(err, &limitErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	if errors.Is(err, ErrBodyTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	if errors.Is(err, ErrUnsupportedEncoding) || errors.Is(err, ErrNotEncripted) {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
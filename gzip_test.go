@@ -1,10 +1,14 @@
 package middlewares
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/gostuding/middlewares/mocks"
@@ -97,3 +101,410 @@ func Test_gzipReader_Close(t *testing.T) {
 		t.Errorf("gzipReader.Close() error: %v", err)
 	}
 }
+
+func Test_myGzipWriter_singleStream(t *testing.T) {
+	m := mocks.NewWMock()
+	w := NewStreamingGzipWriter(m, zap.NewNop().Sugar())
+	w.Header().Set(contentType, applicationJSON)
+	w.WriteHeader(http.StatusOK)
+	for i := 0; i < 10; i++ {
+		_, err := w.Write([]byte("data"))
+		assert.NoError(t, err, "write error")
+		w.Flush()
+	}
+	assert.NoError(t, w.Close(), "close error")
+	reader, err := gzip.NewReader(m)
+	assert.NoError(t, err, "create reader error")
+	reader.Multistream(false)
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err, "read error")
+	assert.Equal(t, strings.Repeat("data", 10), string(data))
+	_, err = reader.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.ErrorIs(t, reader.Reset(m), io.EOF, "response contains several gzip members")
+}
+
+func BenchmarkGzipMiddleware(b *testing.B) {
+	chunk := []byte(strings.Repeat(`{"id":"metric","type":"gauge","value":1.5}`, 20))
+	handler := GzipMiddleware(zap.NewNop().Sugar())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(contentType, applicationJSON)
+		w.WriteHeader(http.StatusOK)
+		for i := 0; i < 10; i++ {
+			w.Write(chunk) //nolint:errcheck //<-benchmark
+		}
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(acceptEncoding, gzipString)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handler.ServeHTTP(mocks.NewWMock(), r)
+	}
+}
+
+func Test_compressConfig_compressible(t *testing.T) {
+	cfg := newCompressConfig()
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{value: applicationJSON, want: true},
+		{value: "application/json; charset=utf-8", want: true},
+		{value: "Text/Plain; version=0.0.4", want: true},
+		{value: "text/html", want: true},
+		{value: "application/vnd.api+json", want: true},
+		{value: "application/atom+xml", want: true},
+		{value: "image/png", want: false},
+		{value: "application/octet-stream", want: false},
+		{value: "broken;;", want: false},
+		{value: "", want: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, cfg.compressible(tt.value), tt.value)
+	}
+	cfg = newCompressConfig(WithContentTypes("Application/*+JSON"))
+	assert.True(t, cfg.compressible("application/problem+json"))
+	assert.False(t, cfg.compressible(applicationJSON))
+}
+
+func Test_myGzipWriter_sniff(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		encoding string
+	}{
+		{name: "HTML", data: []byte("<!DOCTYPE html><html></html>"), encoding: gzipString},
+		{name: "Text", data: []byte("metric_total 1\n"), encoding: gzipString},
+		{name: "PNG", data: []byte("\x89PNG\x0D\x0A\x1A\x0A"), encoding: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := mocks.NewWMock()
+			w := NewStreamingGzipWriter(m, zap.NewNop().Sugar())
+			w.WriteHeader(http.StatusOK)
+			assert.Empty(t, w.Header().Get(contentEncoding), "headers committed before sniffing")
+			_, err := w.Write(tt.data)
+			assert.NoError(t, err)
+			assert.NoError(t, w.Close())
+			assert.NotEmpty(t, w.Header().Get(contentType), "content type not detected")
+			assert.Equal(t, tt.encoding, w.Header().Get(contentEncoding))
+		})
+	}
+}
+
+func Test_myGzipWriter_minSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		length   string
+		writes   []string
+		encoding string
+	}{
+		{name: "Small response", writes: []string{"small", "data"}, encoding: ""},
+		{name: "Threshold reached", writes: []string{"1234567890", "1234567890"}, encoding: gzipString},
+		{name: "Small Content-Length", length: "10", writes: []string{"1234567890"}, encoding: ""},
+		{name: "Large Content-Length", length: "100", writes: []string{"data"}, encoding: gzipString},
+	}
+	cfg := newCompressConfig(WithMinSize(16))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := mocks.NewWMock()
+			encoder, _ := lookupEncoder(gzipString)
+			w := newCompressWriter(m, zap.NewNop().Sugar(), cfg, encoder)
+			w.Header().Set(contentType, applicationJSON)
+			if tt.length != "" {
+				w.Header().Set(contentLength, tt.length)
+			}
+			w.WriteHeader(http.StatusOK)
+			for _, item := range tt.writes {
+				assert.Empty(t, m.Body, "data sent before decision")
+				_, err := w.Write([]byte(item))
+				assert.NoError(t, err)
+				if w.buffering {
+					assert.Empty(t, m.Head.Get(contentEncoding), "encoding committed before decision")
+				}
+			}
+			assert.NoError(t, w.Close())
+			assert.Equal(t, tt.encoding, m.Head.Get(contentEncoding))
+			if tt.encoding == "" {
+				assert.Equal(t, strings.Join(tt.writes, ""), string(m.Body))
+				return
+			}
+			assert.Empty(t, m.Head.Get(contentLength), "stale Content-Length")
+			reader, err := gzip.NewReader(m)
+			if !assert.NoError(t, err) {
+				return
+			}
+			data, err := io.ReadAll(reader)
+			assert.NoError(t, err)
+			assert.Equal(t, strings.Join(tt.writes, ""), string(data))
+		})
+	}
+}
+
+func Test_myGzipWriter_headers(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		header   map[string]string
+		want     map[string]string
+		implicit bool
+	}{
+		{
+			name:   "Compressed",
+			status: http.StatusOK,
+			header: map[string]string{contentType: applicationJSON, contentLength: "4", etagHeader: `"v1"`},
+			want:   map[string]string{contentEncoding: gzipString, varyHeader: acceptEncoding, contentLength: "", etagHeader: `W/"v1"`},
+		},
+		{
+			name:   "Weak ETag",
+			status: http.StatusOK,
+			header: map[string]string{contentType: applicationJSON, etagHeader: `W/"v1"`, varyHeader: "Origin"},
+			want:   map[string]string{contentEncoding: gzipString, varyHeader: "Origin", etagHeader: `W/"v1"`},
+		},
+		{
+			name:   "Error status",
+			status: http.StatusBadRequest,
+			header: map[string]string{contentType: applicationJSON},
+			want:   map[string]string{contentEncoding: gzipString},
+		},
+		{
+			name:     "Implicit WriteHeader",
+			header:   map[string]string{contentType: applicationJSON},
+			want:     map[string]string{contentEncoding: gzipString, varyHeader: acceptEncoding},
+			implicit: true,
+		},
+		{
+			name:   "Encoded by handler",
+			status: http.StatusOK,
+			header: map[string]string{contentType: applicationJSON, contentEncoding: "br", contentLength: "4"},
+			want:   map[string]string{contentEncoding: "br", varyHeader: "", contentLength: "4"},
+		},
+		{
+			name:   "Partial content",
+			status: http.StatusPartialContent,
+			header: map[string]string{contentType: applicationJSON, contentRange: "bytes 0-3/10"},
+			want:   map[string]string{contentEncoding: ""},
+		},
+		{
+			name:   "Not modified",
+			status: http.StatusNotModified,
+			header: map[string]string{contentType: applicationJSON, etagHeader: `"v1"`},
+			want:   map[string]string{contentEncoding: "", etagHeader: `"v1"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writer := NewStreamingGzipWriter(w, zap.NewNop().Sugar())
+			for key, value := range tt.header {
+				writer.Header().Set(key, value)
+			}
+			if !tt.implicit {
+				writer.WriteHeader(tt.status)
+			}
+			if bodyAllowed(tt.status) {
+				_, err := writer.Write([]byte("data"))
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, writer.Close())
+			for key, value := range tt.want {
+				assert.Equal(t, value, w.Header().Get(key), key)
+			}
+		})
+	}
+}
+
+func TestGzipMiddleware_head(t *testing.T) {
+	handler := GzipMiddleware(zap.NewNop().Sugar())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(contentType, applicationJSON)
+		w.WriteHeader(http.StatusOK)
+	}))
+	r := httptest.NewRequest(http.MethodHead, "/", nil)
+	r.Header.Set(acceptEncoding, gzipString)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Empty(t, w.Header().Get(contentEncoding), "HEAD response compressed")
+}
+
+func TestNewCompressMiddleware_vary(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		accept   string
+		ctype    string
+		encoding string
+		vary     string
+	}{
+		{name: "Compressed", method: http.MethodGet, accept: gzipString, ctype: applicationJSON, encoding: gzipString, vary: acceptEncoding},
+		{name: "Without Accept-Encoding", method: http.MethodGet, ctype: applicationJSON, vary: acceptEncoding},
+		{name: "Identity", method: http.MethodGet, accept: "identity", ctype: applicationJSON, vary: acceptEncoding},
+		{name: "Not accepted", method: http.MethodGet, accept: "br", ctype: textHTML, vary: acceptEncoding},
+		{name: "HEAD", method: http.MethodHead, accept: gzipString, ctype: applicationJSON, vary: acceptEncoding},
+		{name: "Not compressible", method: http.MethodGet, accept: gzipString, ctype: "image/png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewCompressMiddleware(zap.NewNop().Sugar(), WithMinSize(0))(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set(contentType, tt.ctype)
+					_, err := w.Write([]byte("data"))
+					assert.NoError(t, err)
+				}))
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.accept != "" {
+				r.Header.Set(acceptEncoding, tt.accept)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.encoding, w.Header().Get(contentEncoding))
+			assert.Equal(t, tt.vary, w.Header().Get(varyHeader))
+		})
+	}
+}
+
+// gzipData compresses data by gzip, each part is written as separate gzip member.
+func gzipData(t *testing.T, parts ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, part := range parts {
+		w := gzip.NewWriter(&buf)
+		_, err := w.Write(part)
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+	}
+	return buf.Bytes()
+}
+
+func Test_gzipReader_limits(t *testing.T) {
+	bomb := make([]byte, 1<<20)
+	tests := []struct {
+		name     string
+		body     []byte
+		encoding string
+		opts     []CompressOption
+		ratio    bool
+		err      bool
+	}{
+		{name: "Under limit", body: gzipData(t, bomb), opts: []CompressOption{WithMaxDecompressedSize(1 << 20)}},
+		{name: "Size limit", body: gzipData(t, bomb), opts: []CompressOption{WithMaxDecompressedSize(1<<20 - 1)}, err: true},
+		{
+			name: "Multi-member stream",
+			body: gzipData(t, bomb[:1<<19], bomb[:1<<19], []byte("x")),
+			opts: []CompressOption{WithMaxDecompressedSize(1 << 20)},
+			err:  true,
+		},
+		{
+			name:     "Nested codings",
+			body:     gzipData(t, gzipData(t, bomb)),
+			encoding: "gzip, gzip",
+			opts:     []CompressOption{WithMaxDecompressedSize(0), WithMaxRatio(100)},
+			ratio:    true,
+			err:      true,
+		},
+		{name: "Ratio limit", body: gzipData(t, bomb), opts: []CompressOption{WithMaxRatio(100)}, ratio: true, err: true},
+		{name: "Ratio under limit", body: gzipData(t, bomb), opts: []CompressOption{WithMaxRatio(2000)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body))
+			r.Header.Set(contentEncoding, gzipString)
+			if tt.encoding != "" {
+				r.Header.Set(contentEncoding, tt.encoding)
+			}
+			assert.NoError(t, decompressBody(r, newCompressConfig(tt.opts...)))
+			data, err := io.ReadAll(r.Body)
+			if !tt.err {
+				assert.NoError(t, err)
+				assert.Equal(t, len(bomb), len(data))
+				return
+			}
+			var limitErr *DecompressLimitError
+			assert.ErrorAs(t, err, &limitErr)
+			if limitErr != nil {
+				assert.Equal(t, tt.ratio, limitErr.Ratio)
+			}
+			assert.LessOrEqual(t, len(data), 1<<20, "data over limit returned")
+		})
+	}
+}
+
+func TestGzipMiddleware_tooLarge(t *testing.T) {
+	tests := []struct {
+		handler http.HandlerFunc
+		name    string
+	}{
+		{
+			name: "Error status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if _, err := io.ReadAll(r.Body); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+				}
+			},
+		},
+		{
+			name: "No response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				io.ReadAll(r.Body) //nolint:errcheck //<-error is not mapped by handler
+			},
+		},
+		{
+			name: "Data response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				io.ReadAll(r.Body) //nolint:errcheck //<-error is not mapped by handler
+				w.Header().Set(contentType, applicationJSON)
+				w.Write([]byte(`{"status":"ok"}`)) //nolint:errcheck //<-test
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewCompressMiddleware(zap.NewNop().Sugar(), WithMaxDecompressedSize(1024))(tt.handler)
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipData(t, make([]byte, 4096))))
+			r.Header.Set(contentEncoding, gzipString)
+			r.Header.Set(acceptEncoding, gzipString)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+			assert.Empty(t, w.Header().Get(contentEncoding))
+			assert.Equal(t, http.StatusText(http.StatusRequestEntityTooLarge), w.Body.String())
+		})
+	}
+
+	handler := NewCompressMiddleware(zap.NewNop().Sugar(), WithMaxDecompressedSize(1024))(
+		HashCheckMiddleware([]byte("key"), zap.NewNop().Sugar())(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				t.Error("handler called with too large body")
+			})))
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipData(t, make([]byte, 4096))))
+	r.Header.Set(contentEncoding, gzipString)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	handler = NewCompressMiddleware(zap.NewNop().Sugar(), WithMaxDecompressedSize(1<<20))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			w.WriteHeader(http.StatusCreated)
+		}))
+	r = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipData(t, make([]byte, 4096))))
+	r.Header.Set(contentEncoding, gzipString)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusCreated, w.Code, "body under limit rejected")
+}
+
+func TestGzipMiddleware_unsupportedEncoding(t *testing.T) {
+	handler := GzipMiddleware(zap.NewNop().Sugar())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("handler called with unsupported content coding")
+		}))
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+	r.Header.Set(contentEncoding, "br")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.Contains(t, w.Header().Get(acceptEncoding), gzipString)
+	assert.Contains(t, w.Header().Get(acceptEncoding), deflateString)
+}
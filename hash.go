@@ -4,37 +4,117 @@ import (
 	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"go.uber.org/zap"
 )
 
-type hashWriter struct {
-	http.ResponseWriter
-	key  []byte
-	body []byte
+// Internal types.
+type (
+	// Struct for write response hash summ.
+	// HMAC of the full body is calculated once while data is written.
+	hashWriter struct {
+		http.ResponseWriter
+		mac         hash.Hash
+		key         []byte
+		alg         string
+		body        []byte
+		limit       int64
+		status      int
+		trailer     bool
+		wroteHeader bool
+	}
+	// Struct for write response hash summ by NewHashWriter.
+	// Hash summ header is updated on every Write, data is sent as is.
+	hashHeaderWriter struct {
+		http.ResponseWriter
+		mac hash.Hash
+	}
+)
+
+// Default limit of response body buffered for hash summ header.
+const defaultHashBufferSize = 8 << 20
+
+// NewHashWriter creates new hash writer. Data is sent on every Write and hash summ
+// header is updated, so the header covers data written before headers were sent.
+// Use NewBufferedHashWriter to send hash summ of the full body.
+func NewHashWriter(r http.ResponseWriter, key []byte) *hashHeaderWriter {
+	w := hashHeaderWriter{ResponseWriter: r}
+	if key != nil {
+		w.mac = hmac.New(sha256.New, key)
+	}
+	return &w
 }
 
-// NewHashWriter creates new hash writer.
-func NewHashWriter(r http.ResponseWriter, key []byte) *hashWriter {
-	return &hashWriter{ResponseWriter: r, key: key, body: nil}
+// NewBufferedHashWriter creates new hash writer. Response body is buffered and
+// sent with hash summ header of the full body by Close, so Close must be called
+// after the last Write. Body larger than 8 MiB is streamed and hash summ is sent
+// in HTTP trailer.
+func NewBufferedHashWriter(r http.ResponseWriter, key []byte) *hashWriter {
+	return newHashWriter(r, key, false)
 }
 
-// Write sets hash summ for bosy in response header.
+// newHashWriter creates hash writer. In trailer mode body is not buffered
+// and hash summ is sent in HTTP trailer.
+func newHashWriter(r http.ResponseWriter, key []byte, trailer bool) *hashWriter {
+	return &hashWriter{ResponseWriter: r, key: key, trailer: trailer, limit: defaultHashBufferSize}
+}
+
+// Write sets hash summ of data written so far in response header.
+func (r *hashHeaderWriter) Write(b []byte) (int, error) {
+	if r.mac != nil {
+		r.mac.Write(b) //nolint:errcheck //<-hash.Hash never returns error
+		r.Header().Set(hashVarName, hex.EncodeToString(r.mac.Sum(nil)))
+	}
+	size, err := r.ResponseWriter.Write(b)
+	if err != nil {
+		return 0, fmt.Errorf("response write error: %w", err)
+	}
+	return size, nil
+}
+
+// Unwrap returns the underlying writer.
+func (r *hashHeaderWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Write calculates hash summ for body. Body is buffered if hash summ is sent in header.
+// When buffered body exceeds the limit, writer switches to trailer mode.
 func (r *hashWriter) Write(b []byte) (int, error) {
-	if r.key != nil {
-		data := append(r.body[:], b[:]...) //nolint:gocritic //<-should be
-		h := hmac.New(sha256.New, r.key)
-		_, err := h.Write(data)
-		if err != nil {
-			return 0, fmt.Errorf("write body hash summ error: %w", err)
+	if r.key == nil {
+		return r.write(b)
+	}
+	if r.mac == nil {
+		r.mac = hmac.New(hashAlgorithm(r.alg), r.key)
+	}
+	r.mac.Write(b) //nolint:errcheck //<-hash.Hash never returns error
+	if !r.trailer {
+		if r.limit <= 0 || int64(len(r.body)+len(b)) <= r.limit {
+			r.body = append(r.body, b...)
+			return len(b), nil
+		}
+		if err := r.startTrailer(); err != nil {
+			return 0, err
 		}
-		r.body = data
-		r.Header().Set(hashVarName, hex.EncodeToString(h.Sum(nil)))
 	}
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	return r.write(b)
+}
+
+// write writes data to the underlying writer.
+func (r *hashWriter) write(b []byte) (int, error) {
 	size, err := r.ResponseWriter.Write(b)
 	if err != nil {
 		return 0, fmt.Errorf("response write error: %w", err)
@@ -42,46 +122,402 @@ func (r *hashWriter) Write(b []byte) (int, error) {
 	return size, nil
 }
 
-func checkHash(data, key []byte, hash string) error {
-	if len(data) > 0 && hash != "" {
-		h := hmac.New(sha256.New, key)
-		_, err := h.Write(data)
-		if err != nil {
-			return fmt.Errorf("write hash summ error: %w", err)
+// startTrailer switches writer to trailer mode: status is sent with hash summ
+// trailer declaration, and buffered body is sent.
+func (r *hashWriter) startTrailer() error {
+	status := r.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	r.trailer = true
+	r.status = 0
+	r.WriteHeader(status)
+	body := r.body
+	r.body = nil
+	if len(body) == 0 {
+		return nil
+	}
+	_, err := r.write(body)
+	return err
+}
+
+// WriteHeader declares hash summ trailer in trailer mode. In buffer mode
+// status is sent by Close.
+func (r *hashWriter) WriteHeader(statusCode int) {
+	if r.key == nil || statusCode >= http.StatusContinue && statusCode < http.StatusOK {
+		r.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if r.status != 0 {
+		return
+	}
+	r.status = statusCode
+	if r.trailer {
+		r.Header().Add("Trailer", hashVarName)
+		r.Header().Del(contentLength)
+		r.wroteHeader = true
+		r.ResponseWriter.WriteHeader(statusCode)
+	}
+}
+
+// Flush sends data to client in trailer mode. Buffered body can't be flushed
+// before hash summ is calculated.
+func (r *hashWriter) Flush() {
+	if r.key != nil && !r.trailer {
+		return
+	}
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close sets hash summ of the full body in header or trailer and sends buffered body.
+// Responses without body are signed too.
+func (r *hashWriter) Close() error {
+	if r.key == nil {
+		return nil
+	}
+	if r.mac == nil {
+		r.mac = hmac.New(hashAlgorithm(r.alg), r.key)
+	}
+	r.Header().Set(hashVarName, formatHash(r.alg, r.mac.Sum(nil)))
+	if r.wroteHeader {
+		return nil
+	}
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if r.Header().Get(contentLength) == "" && bodyAllowed(r.status) {
+		r.Header().Set(contentLength, strconv.Itoa(len(r.body)))
+	}
+	r.wroteHeader = true
+	r.ResponseWriter.WriteHeader(r.status)
+	body := r.body
+	r.body = nil
+	if len(body) == 0 {
+		return nil
+	}
+	if _, err := r.write(body); err != nil {
+		return err
+	}
+	return nil
+}
+
+// reject drops buffered response and sends status with its text. Headers of the
+// handler response are removed. Response already sent in trailer mode is left
+// without hash summ, so client can't accept it.
+func (r *hashWriter) reject(statusCode int) {
+	r.key = nil
+	r.mac = nil
+	r.body = nil
+	if r.wroteHeader {
+		return
+	}
+	for _, name := range []string{
+		contentType, contentLength, contentEncoding, contentRange, etagHeader, "Last-Modified", "Trailer", hashVarName,
+	} {
+		r.Header().Del(name)
+	}
+	r.Header().Set(contentType, "text/plain; charset=utf-8")
+	r.wroteHeader = true
+	r.ResponseWriter.WriteHeader(statusCode)
+	r.ResponseWriter.Write([]byte(http.StatusText(statusCode))) //nolint:errcheck //<-response is rejected
+}
+
+// Unwrap returns the underlying writer.
+func (r *hashWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Hash summ algorithms. Algorithm is announced in hash summ value: "sha512=<hex or base64>".
+// Value without algorithm is hex encoded HMAC-SHA256, as sent by legacy agents.
+const (
+	HashAlgSHA256 = "sha256" // HMAC-SHA256.
+	HashAlgSHA384 = "sha384" // HMAC-SHA384.
+	HashAlgSHA512 = "sha512" // HMAC-SHA512.
+
+	hashKeyIDName        = "HashKeyID"
+	maxHashAlgorithmName = 16
+)
+
+// Hash summ check errors.
+var (
+	ErrHashMissing   = errors.New("hash summ is required")         // Request has body, but has not hash summ.
+	ErrHashMalformed = errors.New("hash summ is malformed")        // Hash summ is not hex or base64 encoded HMAC.
+	ErrHashMismatch  = errors.New("incorrect hash summ")           // Hash summ is not equal to body HMAC.
+	ErrHashAlgorithm = errors.New("hash algorithm is not allowed") // Algorithm is unknown or not allowed.
+	ErrHashKey       = errors.New("hash key is not found")         // Key ID of request is unknown.
+)
+
+// Supported hash summ algorithms.
+var hashAlgorithms = map[string]func() hash.Hash{
+	HashAlgSHA256: sha256.New,
+	HashAlgSHA384: sha512.New384,
+	HashAlgSHA512: sha512.New,
+}
+
+// HashKeyLookup returns HMAC key by key ID of "HashKeyID" header. Empty key ID means the active key.
+// Method Lookup of keys.Ring[[]byte] may be used as HashKeyLookup.
+type HashKeyLookup func(kid string) ([]byte, error)
+
+// hashAlgorithm returns hash function of algorithm, HMAC-SHA256 is default.
+func hashAlgorithm(alg string) func() hash.Hash {
+	if fn, ok := hashAlgorithms[alg]; ok {
+		return fn
+	}
+	return sha256.New
+}
+
+// formatHash returns hash summ header value. SHA256 hash summ is sent without
+// algorithm for legacy agents.
+func formatHash(alg string, sum []byte) string {
+	if alg == "" || alg == HashAlgSHA256 {
+		return hex.EncodeToString(sum)
+	}
+	return alg + "=" + hex.EncodeToString(sum)
+}
+
+// parseHash returns algorithm and decoded value of hash summ header.
+func parseHash(value string) (string, []byte, error) {
+	alg, encoded := HashAlgSHA256, value
+	if i := strings.IndexByte(value, '='); i > 0 && isHashAlgorithmName(value[:i]) {
+		alg, encoded = strings.ToLower(value[:i]), value[i+1:]
+		if _, ok := hashAlgorithms[alg]; !ok {
+			return "", nil, fmt.Errorf("%w: %s", ErrHashAlgorithm, alg)
+		}
+	}
+	size := hashAlgorithm(alg)().Size()
+	if sum, err := hex.DecodeString(encoded); err == nil && len(sum) == size {
+		return alg, sum, nil
+	}
+	if encoded != value {
+		for _, encoding := range []*base64.Encoding{
+			base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding,
+		} {
+			if sum, err := encoding.DecodeString(encoded); err == nil && len(sum) == size {
+				return alg, sum, nil
+			}
 		}
-		if hash != hex.EncodeToString(h.Sum(nil)) {
-			return fmt.Errorf("incorrect hash summ: %s", hash)
+	}
+	return "", nil, fmt.Errorf("%w: %s", ErrHashMalformed, value)
+}
+
+// isHashAlgorithmName checks that value looks like algorithm name, not like encoded hash summ.
+func isHashAlgorithmName(value string) bool {
+	if len(value) > maxHashAlgorithmName {
+		return false
+	}
+	for i, c := range strings.ToLower(value) {
+		if !(c >= 'a' && c <= 'z' || i > 0 && (c >= '0' && c <= '9' || c == '-')) {
+			return false
 		}
 	}
+	return true
+}
+
+// compareHash compares decoded hash summ with calculated HMAC in constant time.
+func compareHash(sum, mac []byte, hash string) error {
+	if !hmac.Equal(sum, mac) {
+		return fmt.Errorf("%w: %s", ErrHashMismatch, hash)
+	}
 	return nil
 }
 
+// Internal types.
+type (
+	// HashCheckMiddleware settings.
+	hashConfig struct {
+		replay     *ReplayGuard
+		keys       HashKeyLookup
+		algorithms []string
+		keyID      string
+		spoolDir   string
+		spool      int64
+		buffer     int64
+		trailer    bool
+		strict     bool
+		stream     bool
+		canonical  bool
+		multipart  bool
+		uploads    UploadStore
+	}
+)
+
+// HashOption sets HashCheckMiddleware settings.
+type HashOption func(*hashConfig)
+
+// WithHashReplayGuard enables replay protection.
+// Requests must contain "X-Request-Timestamp" and "X-Request-Nonce" headers, and
+// hash summ is calculated for: timestamp + "\n" + nonce + "\n" + body.
+func WithHashReplayGuard(guard *ReplayGuard) HashOption {
+	return func(c *hashConfig) {
+		c.replay = guard
+	}
+}
+
+// WithHashTrailer enables sending of response hash summ in HTTP trailer.
+// Response body is streamed to client, and "Trailer: HashSHA256" header is declared.
+// By default response body is buffered and hash summ is sent in header.
+func WithHashTrailer() HashOption {
+	return func(c *hashConfig) {
+		c.trailer = true
+	}
+}
+
+// WithHashBufferLimit sets limit of response body buffered to send hash summ in header.
+// Larger responses are streamed to client and hash summ is sent in HTTP trailer.
+// Zero size disables the limit. Default is 8 MiB.
+func WithHashBufferLimit(size int64) HashOption {
+	return func(c *hashConfig) {
+		c.buffer = size
+	}
+}
+
+// WithStrictHash enables strict mode. Every POST, PUT, PATCH and DELETE request with body
+// must have valid hash summ, and hash summ of request without body is checked if it is set.
+// Requests are rejected with 401 status if hash summ is missing or its key is unknown, 400 if it is malformed
+// and 403 if it is not equal to body HMAC.
+// By default only POST requests with body and hash summ are checked, for legacy agents.
+func WithStrictHash() HashOption {
+	return func(c *hashConfig) {
+		c.strict = true
+	}
+}
+
+// WithHashAlgorithms sets algorithms allowed for request hash summ. The first algorithm
+// is used for response hash summ of requests without hash summ, otherwise response hash summ
+// is calculated by the request algorithm. Unsupported algorithms are ignored.
+// By default all supported algorithms are allowed and HMAC-SHA256 is used for responses.
+func WithHashAlgorithms(algorithms ...string) HashOption {
+	return func(c *hashConfig) {
+		c.algorithms = make([]string, 0, len(algorithms))
+		for _, alg := range algorithms {
+			if _, ok := hashAlgorithms[alg]; ok {
+				c.algorithms = append(c.algorithms, alg)
+			}
+		}
+	}
+}
+
+// WithHashKeys sets keyring of HMAC keys, the key is selected by "HashKeyID" request header.
+// Requests without the header use the active key, so agents and server may rotate keys:
+// the new key is added to ring, agents switch to it, and the retiring key is removed.
+// Response hash summ is calculated by the request key and "HashKeyID" header is returned.
+// Key of NewHashCheckMiddleware is not used if keyring is set.
+func WithHashKeys(keys HashKeyLookup) HashOption {
+	return func(c *hashConfig) {
+		c.keys = keys
+	}
+}
+
+// WithHashKeyID sets key ID of requests signed by NewHashTransport.
+// The key is selected by WithHashKeys lookup and sent in "HashKeyID" header.
+func WithHashKeyID(kid string) HashOption {
+	return func(c *hashConfig) {
+		c.keyID = kid
+	}
+}
+
 // HashCheckMiddleware checks hash summ for request body.
 // Hash must be in request Header: "HashSHA256": "...hassumm...".
 func HashCheckMiddleware(
 	hashKey []byte,
 	logger *zap.SugaredLogger,
 ) func(h http.Handler) http.Handler {
+	return NewHashCheckMiddleware(hashKey, logger)
+}
+
+// NewHashCheckMiddleware checks hash summ for request body with settings from options.
+func NewHashCheckMiddleware(
+	hashKey []byte,
+	logger *zap.SugaredLogger,
+	opts ...HashOption,
+) func(h http.Handler) http.Handler {
+	cfg := hashConfig{spool: math.MaxInt64, buffer: defaultHashBufferSize}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.keys == nil && len(hashKey) > 0 {
+		cfg.keys = func(string) ([]byte, error) {
+			return hashKey, nil
+		}
+	}
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
-			if len(hashKey) > 0 && r.Method == http.MethodPost {
-				data, err := io.ReadAll(r.Body)
-				if err != nil {
-					logger.Warnf(getError(ReadBodyError, err).Error())
+			if cfg.keys != nil && cfg.covers(r.Method) {
+				kid := r.Header.Get(hashKeyIDName)
+				key, err := cfg.keys(kid)
+				if err != nil || len(key) == 0 {
+					w.WriteHeader(cfg.errorStatus(ErrHashKey))
+					logger.Warnf("hash key '%s' error: %v", kid, err)
 					return
 				}
-				if err = r.Body.Close(); err != nil {
-					logger.Warnf(getError(CloseBodyError, err).Error())
+				if r, err = cfg.startUpload(w, r); err != nil {
+					w.WriteHeader(cfg.errorStatus(err))
+					logger.Warnf("hash upload error: %v", err)
 					return
 				}
-				err = checkHash(data, hashKey, r.Header.Get(hashVarName))
-				if err != nil {
-					w.WriteHeader(http.StatusBadRequest)
-					logger.Warnf("hash checker error: %w", err)
-					return
+				data, buffered := requestBody(r)
+				vr := cfg.newVerifyingReader(r, key)
+				stream := cfg.stream && !cfg.isMultipart(r) && uploadChunk(r) == nil
+				if stream {
+					r.Body = vr
+				} else {
+					body, err := cfg.spoolBody(vr, data, buffered)
+					if err != nil {
+						w.WriteHeader(cfg.bodyErrorStatus(vr))
+						logger.Warnf("hash checker error: %v", err)
+						return
+					}
+					defer func() {
+						if err := body.Close(); err != nil {
+							logger.Warnf(err.Error())
+						}
+					}()
+					if err = vr.Close(); err != nil {
+						logger.Warnf(getError(CloseBodyError, err).Error())
+						return
+					}
+					if cfg.isMultipart(r) {
+						if err = cfg.checkParts(r, body, key); err == nil {
+							err = body.rewind()
+						}
+						if err != nil {
+							w.WriteHeader(cfg.errorStatus(err))
+							logger.Warnf("hash checker error: %v", err)
+							return
+						}
+					}
+					if err = cfg.reserveUpload(w, r, vr); err != nil {
+						w.WriteHeader(cfg.errorStatus(err))
+						logger.Warnf("hash upload error: %v", err)
+						return
+					}
+					if buffered {
+						r = setBody(r, data)
+					} else {
+						r.Body = io.NopCloser(body)
+					}
+				}
+				if kid != "" {
+					w.Header().Set(hashKeyIDName, kid)
+				}
+				hw := newHashWriter(w, key, cfg.trailer)
+				hw.alg = cfg.responseAlgorithm(r.Header.Get(hashVarName))
+				hw.limit = cfg.buffer
+				next.ServeHTTP(wrapWriter(hw), r)
+				if stream {
+					if err = vr.Verify(); err != nil {
+						hw.reject(cfg.bodyErrorStatus(vr))
+						logger.Warnf("hash checker error: %v", err)
+					}
+				}
+				if err = cfg.releaseUpload(r, hw, vr); err != nil {
+					logger.Warnf("hash upload error: %v", err)
+				}
+				if err = hw.Close(); err != nil {
+					logger.Warnf("hash writer error: %v", err)
 				}
-				r.Body = io.NopCloser(bytes.NewReader(data))
-				next.ServeHTTP(NewHashWriter(w, hashKey), r)
 			} else {
 				next.ServeHTTP(w, r)
 			}
@@ -89,3 +525,155 @@ func HashCheckMiddleware(
 		return http.HandlerFunc(fn)
 	}
 }
+
+// spoolBody verifies request body and returns it spooled. Body data of RequestBody
+// context value is used if buffered is set, so it is not copied again.
+func (c *hashConfig) spoolBody(vr *verifyingReader, data []byte, buffered bool) (*spooledBody, error) {
+	if !buffered {
+		return spoolBody(vr, c.spool, c.spoolDir)
+	}
+	if err := vr.Verify(); err != nil {
+		return nil, err
+	}
+	return &spooledBody{Reader: bytes.NewReader(data), memory: data}, nil
+}
+
+// check checks request hash summ and replay protection values.
+func (c *hashConfig) check(r *http.Request, data, key []byte) error {
+	mac := c.newMAC(r, key)
+	mac.Write(data) //nolint:errcheck //<-hash.Hash never returns error
+	sum := mac.Sum(nil)
+	if err := canonicalError(mac); err != nil {
+		return err
+	}
+	return c.checkSum(r, int64(len(data)), sum)
+}
+
+// newMAC creates HMAC by algorithm of request hash summ for body of request.
+func (c *hashConfig) newMAC(r *http.Request, key []byte) hash.Hash {
+	alg, _, err := parseHash(r.Header.Get(hashVarName))
+	if err != nil {
+		alg = HashAlgSHA256
+	}
+	var chain []byte
+	if chunk := uploadChunk(r); chunk != nil {
+		chain = chunk.State.Chain
+	}
+	return c.prefixMAC(r, hmac.New(hashAlgorithm(alg), key), chain)
+}
+
+// prefixMAC writes values signed before body to HMAC. In replay protection mode
+// timestamp and nonce are written, chain of upload is written after them. In canonical JSON
+// mode HMAC of JSON body is calculated for its canonical form.
+// The same HMAC is created by SignUploadChunk.
+func (c *hashConfig) prefixMAC(r *http.Request, mac hash.Hash, chain []byte) hash.Hash {
+	if c.replay != nil {
+		mac.Write(replayParams(r).bytes()) //nolint:errcheck //<-hash.Hash never returns error
+	}
+	mac.Write(chain) //nolint:errcheck //<-hash.Hash never returns error
+	if c.canonical && isJSONRequest(r) {
+		return newCanonicalMAC(mac)
+	}
+	return mac
+}
+
+// checkSum checks request hash summ by HMAC calculated for body of size
+// and replay protection values.
+func (c *hashConfig) checkSum(r *http.Request, size int64, mac []byte) error {
+	hash := r.Header.Get(hashVarName)
+	if err := c.allow(hash); err != nil {
+		return err
+	}
+	chunk := uploadChunk(r)
+	if hash == "" {
+		if chunk == nil && c.replay == nil && (!c.strict || size == 0 || c.isMultipart(r)) {
+			return nil
+		}
+		return ErrHashMissing
+	}
+	if chunk == nil && c.replay == nil && !c.strict && size == 0 {
+		return nil
+	}
+	_, sum, err := parseHash(hash)
+	if err != nil {
+		return err
+	}
+	if err = compareHash(sum, mac, hash); err != nil || c.replay == nil {
+		return err
+	}
+	params := replayParams(r)
+	return c.replay.Check(params.Timestamp, params.Nonce)
+}
+
+// allow checks that algorithm of hash summ is allowed.
+func (c *hashConfig) allow(hash string) error {
+	if hash == "" || c.algorithms == nil {
+		return nil
+	}
+	alg, _, err := parseHash(hash)
+	if err != nil {
+		return err
+	}
+	for _, item := range c.algorithms {
+		if item == alg {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrHashAlgorithm, alg)
+}
+
+// responseAlgorithm returns algorithm of response hash summ for request hash summ.
+func (c *hashConfig) responseAlgorithm(hash string) string {
+	if alg, _, err := parseHash(hash); hash != "" && err == nil {
+		return alg
+	}
+	if len(c.algorithms) > 0 {
+		return c.algorithms[0]
+	}
+	return HashAlgSHA256
+}
+
+// covers checks that requests with the method are checked.
+func (c *hashConfig) covers(method string) bool {
+	switch method {
+	case http.MethodPost:
+		return true
+	case http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return c.strict
+	default:
+		return false
+	}
+}
+
+// bodyErrorStatus returns response status for error of verifying reader.
+func (c *hashConfig) bodyErrorStatus(v *verifyingReader) int {
+	switch {
+	case v.err != nil:
+		return readErrorStatus(v.err)
+	case v.verifyErr != nil:
+		return c.errorStatus(v.verifyErr)
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// errorStatus returns response status for check error.
+func (c *hashConfig) errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUploadConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrUploadStore):
+		return http.StatusInternalServerError
+	}
+	if !c.strict {
+		return http.StatusBadRequest
+	}
+	switch {
+	case errors.Is(err, ErrHashMissing), errors.Is(err, ErrHashKey):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrHashMismatch):
+		return http.StatusForbidden
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,176 @@
+package middlewares
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+)
+
+// Internal types.
+type (
+	// Reads body through HMAC and verifies it at EOF.
+	verifyingReader struct {
+		body      io.ReadCloser
+		mac       hash.Hash
+		verify    func(size int64, mac []byte) error
+		sum       []byte
+		err       error
+		verifyErr error
+		size      int64
+		done      bool
+	}
+	// Body spooled to memory and temporary file.
+	spooledBody struct {
+		io.Reader
+		file   *os.File
+		memory []byte
+	}
+)
+
+// WithHashStreaming enables streaming verification: request body is not buffered, it is read by
+// handler through HMAC, and Read returns verification error instead of io.EOF.
+// Body which is not read by handler to the end is read and verified after handler.
+// In the default buffer mode of response the failed request gets error status instead of
+// the handler response, in trailer mode the response is left without hash summ.
+// Handlers must not commit effects of data before EOF, replay protection values are
+// checked at EOF too.
+func WithHashStreaming() HashOption {
+	return func(c *hashConfig) {
+		c.stream = true
+	}
+}
+
+// WithHashSpool sets size of request body kept in memory while it is verified, the rest of body
+// is written to temporary file in dir (os.TempDir if dir is empty). Handler gets verified body only,
+// and temporary file is removed after handler. By default the full body is kept in memory.
+func WithHashSpool(memory int64, dir string) HashOption {
+	return func(c *hashConfig) {
+		c.spool = memory
+		c.spoolDir = dir
+	}
+}
+
+// newVerifyingReader creates reader which verifies request body by hash summ at EOF.
+func (c *hashConfig) newVerifyingReader(r *http.Request, key []byte) *verifyingReader {
+	mac := c.newMAC(r, key)
+	return &verifyingReader{
+		body: r.Body,
+		mac:  mac,
+		verify: func(size int64, sum []byte) error {
+			if err := canonicalError(mac); err != nil {
+				return err
+			}
+			return c.checkSum(r, size, sum)
+		},
+	}
+}
+
+// Read reads body and returns verification error at EOF.
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	if v.done {
+		return 0, v.result()
+	}
+	n, err := v.body.Read(p)
+	v.mac.Write(p[:n]) //nolint:errcheck //<-hash.Hash never returns error
+	v.size += int64(n)
+	switch {
+	case errors.Is(err, io.EOF):
+		v.done = true
+		v.sum = v.mac.Sum(nil)
+		v.verifyErr = v.verify(v.size, v.sum)
+		if v.verifyErr != nil {
+			return n, v.verifyErr
+		}
+	case err != nil:
+		v.done = true
+		v.err = getError(ReadBodyError, err)
+		return n, v.err
+	}
+	return n, err //nolint:wrapcheck //<-io.EOF must not be wrapped
+}
+
+// result returns error of finished reading.
+func (v *verifyingReader) result() error {
+	switch {
+	case v.err != nil:
+		return v.err
+	case v.verifyErr != nil:
+		return v.verifyErr
+	default:
+		return io.EOF
+	}
+}
+
+// Verify reads the rest of body and returns read or verification error.
+func (v *verifyingReader) Verify() error {
+	if !v.done {
+		io.Copy(io.Discard, v) //nolint:errcheck //<-error is returned below
+	}
+	if err := v.result(); !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// Close closes body.
+func (v *verifyingReader) Close() error {
+	return v.body.Close() //nolint:wrapcheck //<-senselessly
+}
+
+// spoolBody reads body to memory and temporary file. Body above memory size is written to file.
+func spoolBody(body io.Reader, memory int64, dir string) (*spooledBody, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, body, memory); err != nil {
+		if errors.Is(err, io.EOF) {
+			return &spooledBody{Reader: bytes.NewReader(buf.Bytes()), memory: buf.Bytes()}, nil
+		}
+		return nil, err //nolint:wrapcheck //<-body errors are wrapped by reader
+	}
+	file, err := os.CreateTemp(dir, "body-*")
+	if err != nil {
+		return nil, fmt.Errorf("create spool file error: %w", err)
+	}
+	spooled := &spooledBody{file: file, memory: buf.Bytes()}
+	if _, err = io.Copy(file, body); err != nil {
+		spooled.Close() //nolint:errcheck //<-copy error is returned
+		return nil, err //nolint:wrapcheck //<-body errors are wrapped by reader
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		spooled.Close() //nolint:errcheck //<-seek error is returned
+		return nil, fmt.Errorf("spool file seek error: %w", err)
+	}
+	spooled.Reader = io.MultiReader(bytes.NewReader(buf.Bytes()), file)
+	return spooled, nil
+}
+
+// rewind starts reading of body from the beginning.
+func (s *spooledBody) rewind() error {
+	s.Reader = bytes.NewReader(s.memory)
+	if s.file == nil {
+		return nil
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("spool file seek error: %w", err)
+	}
+	s.Reader = io.MultiReader(s.Reader, s.file)
+	return nil
+}
+
+// Close removes temporary file.
+func (s *spooledBody) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	if rmErr := os.Remove(s.file.Name()); rmErr != nil && err == nil {
+		err = rmErr
+	}
+	if err != nil {
+		return fmt.Errorf("spool file close error: %w", err)
+	}
+	return nil
+}
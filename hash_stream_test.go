@@ -0,0 +1,113 @@
+package middlewares
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestWithHashStreaming(t *testing.T) {
+	key := []byte("key")
+	data := strings.Repeat("metric;", 1000)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data)) //nolint:errcheck //<-test
+	valid := hex.EncodeToString(mac.Sum(nil))
+	tests := []struct {
+		name    string
+		hash    string
+		read    bool
+		trailer bool
+		status  int
+		readErr error
+	}{
+		{name: "Valid", hash: valid, read: true, status: http.StatusOK},
+		{name: "Mismatch", hash: strings.Repeat("00", 32), read: true, status: http.StatusForbidden, readErr: ErrHashMismatch},
+		{name: "Not read body", hash: strings.Repeat("00", 32), status: http.StatusForbidden},
+		{name: "Trailer", hash: strings.Repeat("00", 32), read: true, trailer: true, status: http.StatusOK, readErr: ErrHashMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []HashOption{WithHashStreaming(), WithStrictHash()}
+			if tt.trailer {
+				opts = append(opts, WithHashTrailer())
+			}
+			var readErr error
+			handler := NewHashCheckMiddleware(key, zap.NewNop().Sugar(), opts...)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+					if tt.read {
+						var body []byte
+						body, readErr = io.ReadAll(r.Body)
+						assert.Equal(t, data, string(body), "streamed body")
+					}
+					_, err := w.Write([]byte("response"))
+					assert.NoError(t, err)
+				}))
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(data))
+			r.Header.Set(hashVarName, tt.hash)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code)
+			if tt.readErr != nil {
+				assert.ErrorIs(t, readErr, tt.readErr)
+			} else {
+				assert.NoError(t, readErr)
+			}
+			if tt.status != http.StatusOK || tt.trailer {
+				assert.Empty(t, w.Result().Header.Get(hashVarName), "hash summ of rejected response")
+				assert.Empty(t, w.Result().Trailer.Get(hashVarName), "hash summ of rejected response")
+			} else {
+				assert.NotEmpty(t, w.Header().Get(hashVarName))
+			}
+		})
+	}
+}
+
+func TestWithHashSpool(t *testing.T) {
+	key := []byte("key")
+	data := strings.Repeat("metric;", 1000)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data)) //nolint:errcheck //<-test
+	valid := hex.EncodeToString(mac.Sum(nil))
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name   string
+		hash   string
+		status int
+	}{
+		{name: "Valid", hash: valid, status: http.StatusOK},
+		{name: "Mismatch", hash: strings.Repeat("00", 32), status: http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := NewHashCheckMiddleware(key, zap.NewNop().Sugar(), WithHashSpool(100, dir))(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					called = true
+					files, err := os.ReadDir(dir)
+					assert.NoError(t, err)
+					assert.Len(t, files, 1, "spool file")
+					body, err := io.ReadAll(r.Body)
+					assert.NoError(t, err)
+					assert.Equal(t, data, string(body))
+				}))
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(data))
+			r.Header.Set(hashVarName, tt.hash)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.status == http.StatusOK, called, "handler got unverified body")
+			files, err := os.ReadDir(dir)
+			assert.NoError(t, err)
+			assert.Empty(t, files, "spool file is not removed")
+		})
+	}
+}
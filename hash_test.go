@@ -1,10 +1,22 @@
 package middlewares
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/gostuding/middlewares/keys"
 	"github.com/gostuding/middlewares/mocks"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 )
 
 func Test_hashWriter_Write(t *testing.T) {
@@ -55,6 +67,9 @@ func Test_hashWriter_Write(t *testing.T) {
 				body:           tt.fields.body,
 			}
 			_, err := r.Write(tt.args.b)
+			if err == nil {
+				err = r.Close()
+			}
 			if (err != nil) != tt.wantErr {
 				t.Errorf("hashWriter.Write() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -99,9 +114,284 @@ func Test_checkHash(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := checkHash(tt.args.data, tt.args.key, tt.args.hash); (err != nil) != tt.wantErr {
-				t.Errorf("checkHash() error = %v, wantErr %v", err, tt.wantErr)
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.Header.Set(hashVarName, tt.args.hash)
+			cfg := hashConfig{}
+			if err := cfg.check(r, tt.args.data, tt.args.key); (err != nil) != tt.wantErr {
+				t.Errorf("hashConfig.check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_hashConfig_check(t *testing.T) {
+	tests := []struct {
+		err    error
+		name   string
+		hash   string
+		data   []byte
+		strict bool
+	}{
+		{name: "Null data"},
+		{name: "Without hash", data: []byte("test")},
+		{name: "Strict without hash", data: []byte("test"), strict: true, err: ErrHashMissing},
+		{name: "Test data", data: []byte("test"), hash: "de79cc62d7da11c1f3049dbf73ba060497e3d4e7a07029fa6f48e75cfc681042"},
+		{name: "Short hash", data: []byte("test"), hash: "de79", err: ErrHashMalformed},
+		{name: "Not hex", data: []byte("test"), hash: "not hex", err: ErrHashMalformed},
+		{name: "Mismatch", data: []byte("test"), hash: strings.Repeat("ab", 32), err: ErrHashMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			if tt.hash != "" {
+				r.Header.Set(hashVarName, tt.hash)
+			}
+			cfg := hashConfig{strict: tt.strict}
+			err := cfg.check(r, tt.data, []byte("default"))
+			if tt.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNewHashCheckMiddleware_response(t *testing.T) {
+	key := []byte("key")
+	chunks := []string{"first chunk;", "second chunk;", "third chunk"}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join(chunks, ""))) //nolint:errcheck //<-test
+	want := hex.EncodeToString(mac.Sum(nil))
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(contentType, applicationJSON)
+		w.WriteHeader(http.StatusOK)
+		for _, chunk := range chunks {
+			_, err := w.Write([]byte(chunk))
+			assert.NoError(t, err)
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+	}
+	for _, trailer := range []bool{false, true} {
+		var opts []HashOption
+		if trailer {
+			opts = append(opts, WithHashTrailer())
+		}
+		server := httptest.NewServer(NewHashCheckMiddleware(key, zap.NewNop().Sugar(), opts...)(
+			http.HandlerFunc(handler)))
+		resp, err := http.Post(server.URL, applicationJSON, strings.NewReader("")) //nolint:noctx //<-test
+		if !assert.NoError(t, err) {
+			server.Close()
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		resp.Body.Close() //nolint:errcheck //<-test
+		assert.Equal(t, strings.Join(chunks, ""), string(body))
+		if trailer {
+			assert.Empty(t, resp.Header.Get(hashVarName), "hash summ in header")
+			assert.Equal(t, want, resp.Trailer.Get(hashVarName), "trailer hash summ")
+		} else {
+			assert.Equal(t, want, resp.Header.Get(hashVarName), "header hash summ")
+			assert.Equal(t, int64(len(body)), resp.ContentLength)
+		}
+		server.Close()
+	}
+}
+
+func TestWithHashBufferLimit(t *testing.T) {
+	key := []byte("key")
+	data := strings.Repeat("data", 100)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data)) //nolint:errcheck //<-test
+	want := hex.EncodeToString(mac.Sum(nil))
+	tests := []struct {
+		name    string
+		limit   int64
+		trailer bool
+	}{
+		{name: "Buffered", limit: int64(len(data))},
+		{name: "Without limit", limit: 0},
+		{name: "Exceeded", limit: 10, trailer: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHashCheckMiddleware(key, zap.NewNop().Sugar(), WithHashBufferLimit(tt.limit))(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set(contentType, applicationJSON)
+					for i := 0; i < len(data); i += 40 {
+						_, err := w.Write([]byte(data[i : i+40]))
+						assert.NoError(t, err)
+					}
+				}))
+			server := httptest.NewServer(handler)
+			defer server.Close()
+			resp, err := http.Post(server.URL, applicationJSON, strings.NewReader("")) //nolint:noctx //<-test
+			if !assert.NoError(t, err) {
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			resp.Body.Close() //nolint:errcheck //<-test
+			assert.Equal(t, data, string(body))
+			if tt.trailer {
+				assert.Empty(t, resp.Header.Get(hashVarName), "hash summ in header")
+				assert.Equal(t, want, resp.Trailer.Get(hashVarName), "trailer hash summ")
+			} else {
+				assert.Equal(t, want, resp.Header.Get(hashVarName), "header hash summ")
+			}
+		})
+	}
+}
+
+func Test_hashWriter_reject(t *testing.T) {
+	w := httptest.NewRecorder()
+	hw := newHashWriter(w, []byte("key"), false)
+	hw.Header().Set(contentType, applicationJSON)
+	hw.Header().Set(contentLength, "8")
+	hw.Header().Set(etagHeader, `"v1"`)
+	hw.WriteHeader(http.StatusOK)
+	_, err := hw.Write([]byte(`{"a":1}`))
+	assert.NoError(t, err)
+	hw.reject(http.StatusForbidden)
+	assert.NoError(t, hw.Close())
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get(contentType))
+	assert.Empty(t, w.Header().Get(contentLength), "handler Content-Length")
+	assert.Empty(t, w.Header().Get(etagHeader), "handler ETag")
+	assert.Empty(t, w.Header().Get(hashVarName), "hash summ of rejected response")
+	assert.Equal(t, http.StatusText(http.StatusForbidden), w.Body.String())
+}
+
+func TestWithStrictHash(t *testing.T) {
+	key := []byte("default")
+	valid := "de79cc62d7da11c1f3049dbf73ba060497e3d4e7a07029fa6f48e75cfc681042"
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		hash       string
+		strict     int
+		permissive int
+	}{
+		{name: "Valid", method: http.MethodPost, body: "test", hash: valid, strict: http.StatusOK, permissive: http.StatusOK},
+		{name: "Missing", method: http.MethodPost, body: "test", strict: http.StatusUnauthorized, permissive: http.StatusOK},
+		{name: "Malformed", method: http.MethodPost, body: "test", hash: "zz", strict: http.StatusBadRequest, permissive: http.StatusBadRequest},
+		{
+			name: "Mismatch", method: http.MethodPost, body: "test", hash: strings.Repeat("00", 32),
+			strict: http.StatusForbidden, permissive: http.StatusBadRequest,
+		},
+		{name: "Uppercase", method: http.MethodPost, body: "test", hash: strings.ToUpper(valid), strict: http.StatusOK, permissive: http.StatusOK},
+		{name: "Put", method: http.MethodPut, body: "test", strict: http.StatusUnauthorized, permissive: http.StatusOK},
+		{name: "Patch", method: http.MethodPatch, body: "test", hash: "00", strict: http.StatusBadRequest, permissive: http.StatusOK},
+		{name: "Delete", method: http.MethodDelete, body: "test", strict: http.StatusUnauthorized, permissive: http.StatusOK},
+		{name: "Empty body", method: http.MethodDelete, strict: http.StatusOK, permissive: http.StatusOK},
+		{name: "Get", method: http.MethodGet, strict: http.StatusOK, permissive: http.StatusOK},
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	strict := NewHashCheckMiddleware(key, zap.NewNop().Sugar(), WithStrictHash())(handler)
+	permissive := HashCheckMiddleware(key, zap.NewNop().Sugar())(handler)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, item := range []struct {
+				handler http.Handler
+				want    int
+			}{{strict, tt.strict}, {permissive, tt.permissive}} {
+				r := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+				if tt.hash != "" {
+					r.Header.Set(hashVarName, tt.hash)
+				}
+				w := httptest.NewRecorder()
+				item.handler.ServeHTTP(w, r)
+				assert.Equal(t, item.want, w.Code)
+			}
+		})
+	}
+}
+
+func Test_parseHash(t *testing.T) {
+	sum256 := strings.Repeat("ab", sha256.Size)
+	sum512 := bytes.Repeat([]byte{0xab}, sha512.Size)
+	tests := []struct {
+		name  string
+		value string
+		alg   string
+		err   error
+	}{
+		{name: "Legacy", value: sum256, alg: HashAlgSHA256},
+		{name: "SHA256", value: "sha256=" + sum256, alg: HashAlgSHA256},
+		{name: "SHA512 hex", value: "sha512=" + hex.EncodeToString(sum512), alg: HashAlgSHA512},
+		{name: "SHA512 base64", value: "SHA512=" + base64.StdEncoding.EncodeToString(sum512), alg: HashAlgSHA512},
+		{name: "SHA512 base64url", value: "sha512=" + base64.RawURLEncoding.EncodeToString(sum512), alg: HashAlgSHA512},
+		{name: "Unknown algorithm", value: "md5=" + sum256, err: ErrHashAlgorithm},
+		{name: "Wrong size", value: "sha512=" + sum256, err: ErrHashMalformed},
+		{name: "Legacy base64", value: base64.StdEncoding.EncodeToString(sum512[:sha256.Size]), err: ErrHashMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alg, _, err := parseHash(tt.value)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.alg, alg)
+		})
+	}
+}
+
+func TestWithHashKeys(t *testing.T) {
+	ring := keys.NewRing[[]byte]()
+	ring.Add("k1", []byte("old key"))
+	ring.Add("k2", []byte("new key"))
+	sign := func(alg string, key []byte, data string) string {
+		mac := hmac.New(hashAlgorithm(alg), key)
+		mac.Write([]byte(data)) //nolint:errcheck //<-test
+		return formatHash(alg, mac.Sum(nil))
+	}
+	handler := NewHashCheckMiddleware(nil, zap.NewNop().Sugar(),
+		WithHashKeys(ring.Lookup), WithHashAlgorithms(HashAlgSHA512, HashAlgSHA256), WithStrictHash(),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.Copy(w, r.Body)
+		assert.NoError(t, err)
+	}))
+	tests := []struct {
+		name   string
+		kid    string
+		key    string
+		alg    string
+		status int
+	}{
+		{name: "Active key", key: "old key", alg: HashAlgSHA256, status: http.StatusOK},
+		{name: "Retiring key", kid: "k1", key: "old key", alg: HashAlgSHA256, status: http.StatusOK},
+		{name: "New key", kid: "k2", key: "new key", alg: HashAlgSHA512, status: http.StatusOK},
+		{name: "Wrong key", kid: "k2", key: "old key", alg: HashAlgSHA512, status: http.StatusForbidden},
+		{name: "Unknown key", kid: "k3", key: "old key", alg: HashAlgSHA512, status: http.StatusUnauthorized},
+		{name: "Not allowed algorithm", kid: "k2", key: "new key", alg: HashAlgSHA384, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+			r.Header.Set(hashVarName, sign(tt.alg, []byte(tt.key), "data"))
+			if tt.kid != "" {
+				r.Header.Set(hashKeyIDName, tt.kid)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, sign(tt.alg, []byte(tt.key), "data"), w.Header().Get(hashVarName), "response hash summ")
+				assert.Equal(t, tt.kid, w.Header().Get(hashKeyIDName))
 			}
 		})
 	}
+	assert.NoError(t, ring.SetActive("k2"))
+	assert.NoError(t, ring.Remove("k1"))
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+	r.Header.Set(hashVarName, sign(HashAlgSHA256, []byte("old key"), "data"))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code, "removed key is accepted")
 }
@@ -0,0 +1,397 @@
+package middlewares
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	uploadIDHeader     = "HashUploadID"
+	uploadOffsetHeader = "HashUploadOffset"
+	uploadChainHeader  = "HashUploadChain"
+	maxUploadIDLength  = 128
+	// Default time to live of upload state in MemoryUploadStore.
+	defaultUploadTTL = 24 * time.Hour
+	// Default count of uploads in MemoryUploadStore.
+	defaultUploadCapacity = 10000
+)
+
+var (
+	ErrHashPart       = errors.New("multipart part hash summ error")     // Part hash summ is missing or incorrect.
+	ErrUploadConflict = errors.New("upload chunk offset conflict")       // Chunk offset is not offset of upload.
+	ErrUploadStore    = errors.New("upload store error")                 // Upload state can't be loaded or stored.
+	ErrUploadChunk    = errors.New("upload chunk headers are incorrect") // Upload ID or chunk offset is invalid.
+)
+
+// UploadState is state of chunked upload after the last accepted chunk.
+type UploadState struct {
+	Chain  []byte // HMAC of the last accepted chunk, empty for new upload.
+	Offset int64  // Size of accepted data.
+}
+
+// UploadChunk is chunk of upload checked by NewHashCheckMiddleware.
+type UploadChunk struct {
+	ID    string      // Upload ID.
+	State UploadState // State of upload before the chunk, chunk offset is State.Offset.
+}
+
+// UploadStore keeps states of chunked uploads.
+type UploadStore interface {
+	// Load returns state of upload, zero state for new upload.
+	Load(id string) (UploadState, error)
+	// Store replaces state of upload. Returns ErrUploadConflict if current state is not prev.
+	Store(id string, prev, next UploadState) error
+}
+
+// Upload state in MemoryUploadStore.
+type uploadItem struct {
+	expires time.Time
+	id      string
+	state   UploadState
+}
+
+// MemoryUploadStore is a bounded in-memory UploadStore.
+// State of upload is removed by Delete or when it is not updated during time to live.
+// When the store is full, the least recently updated upload is evicted, so the next
+// chunk of evicted upload is rejected with 409 status and zero offset.
+type MemoryUploadStore struct {
+	states   map[string]*list.Element
+	order    *list.List
+	now      func() time.Time
+	ttl      time.Duration
+	capacity int
+	mutex    sync.Mutex
+}
+
+// NewMemoryUploadStore creates in-memory upload store. If ttl or capacity is not
+// positive, default value is used: 24 hours and 10000 uploads.
+func NewMemoryUploadStore(ttl time.Duration, capacity int) *MemoryUploadStore {
+	if ttl <= 0 {
+		ttl = defaultUploadTTL
+	}
+	if capacity <= 0 {
+		capacity = defaultUploadCapacity
+	}
+	return &MemoryUploadStore{
+		states:   make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+		ttl:      ttl,
+		capacity: capacity,
+	}
+}
+
+// Load returns state of upload.
+func (s *MemoryUploadStore) Load(id string) (UploadState, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expire()
+	if item, ok := s.states[id]; ok {
+		return item.Value.(*uploadItem).state, nil //nolint:forcetypeassert //<-only uploadItem in list
+	}
+	return UploadState{}, nil
+}
+
+// Store replaces state of upload if current state is prev.
+func (s *MemoryUploadStore) Store(id string, prev, next UploadState) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expire()
+	var current UploadState
+	item, ok := s.states[id]
+	if ok {
+		current = item.Value.(*uploadItem).state //nolint:forcetypeassert //<-only uploadItem in list
+	}
+	if current.Offset != prev.Offset || !bytes.Equal(current.Chain, prev.Chain) {
+		return ErrUploadConflict
+	}
+	value := &uploadItem{id: id, state: next, expires: s.now().Add(s.ttl)}
+	if ok {
+		item.Value = value
+		s.order.MoveToBack(item)
+		return nil
+	}
+	for s.order.Len() >= s.capacity {
+		s.remove(s.order.Front())
+	}
+	s.states[id] = s.order.PushBack(value)
+	return nil
+}
+
+// Delete removes state of upload.
+func (s *MemoryUploadStore) Delete(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if item, ok := s.states[id]; ok {
+		s.remove(item)
+	}
+}
+
+// Len returns count of stored uploads.
+func (s *MemoryUploadStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expire()
+	return s.order.Len()
+}
+
+// expire removes uploads which are not updated during time to live.
+// Uploads are ordered by update time, so expired uploads are at the front.
+func (s *MemoryUploadStore) expire() {
+	now := s.now()
+	for item := s.order.Front(); item != nil; item = s.order.Front() {
+		if item.Value.(*uploadItem).expires.After(now) { //nolint:forcetypeassert //<-only uploadItem in list
+			return
+		}
+		s.remove(item)
+	}
+}
+
+// remove removes upload item.
+func (s *MemoryUploadStore) remove(item *list.Element) {
+	s.order.Remove(item)
+	delete(s.states, item.Value.(*uploadItem).id) //nolint:forcetypeassert //<-only uploadItem in list
+}
+
+// WithHashMultipart enables hash summ check of every part of multipart requests.
+// Hash summ of part content is sent in "HashSHA256" header of the part (see SetPartHash)
+// and is required for all parts with WithStrictHash option. Hash summ of the full body
+// is not required for multipart requests, but it is checked if sent: part hash summs
+// don't protect order and count of parts. Multipart requests are spooled in
+// WithHashStreaming mode too.
+func WithHashMultipart() HashOption {
+	return func(c *hashConfig) {
+		c.multipart = true
+	}
+}
+
+// WithHashUploads enables chunked uploads. Chunk of upload is sent with "HashUploadID" and
+// "HashUploadOffset" (offset of chunk data) headers, and its hash summ is HMAC of the
+// previous chunk HMAC (chain) and the chunk, so chunks are accepted in order only.
+// Chunk with offset which is not offset of upload is rejected with 409 status, and
+// "HashUploadOffset" and "HashUploadChain" headers of response are state to resume
+// the upload (see UploadStateFromHeader). State after the chunk is stored before handler is called,
+// so concurrent chunks with the same offset are not passed to handler, and the previous state is
+// restored if handler response status is not 2xx. Handler gets *UploadChunk as HashUpload
+// context value. Chunks are spooled in WithHashStreaming mode too.
+// Chunked uploads are not supported by HashStage.
+func WithHashUploads(store UploadStore) HashOption {
+	return func(c *hashConfig) {
+		c.uploads = store
+	}
+}
+
+// SetPartHash sets hash summ of multipart part data to part header.
+// Algorithm is one of HashAlgSHA256, HashAlgSHA384 or HashAlgSHA512.
+func SetPartHash(header textproto.MIMEHeader, data, key []byte, alg string) error {
+	hash, err := signHash(alg, key, data)
+	if err != nil {
+		return err
+	}
+	header.Set(hashVarName, hash)
+	return nil
+}
+
+// SignUploadChunk sets chunk as request body, upload headers and hash summ chained from
+// state of upload. Returns state of upload after the chunk.
+// Options of NewHashCheckMiddleware, which change hash summ, must be the same as server ones:
+//   - WithHashReplayGuard: replay protection values must be set to request headers
+//     (see ReplayParams.SetHeaders) before the call, the guard is not used;
+//   - WithHashCanonicalJSON: Content-Type must be set before the call.
+func SignUploadChunk(
+	r *http.Request,
+	id string,
+	state UploadState,
+	chunk, key []byte,
+	alg string,
+	opts ...HashOption,
+) (UploadState, error) {
+	var cfg hashConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	mac, err := newHashMAC(alg, key)
+	if err != nil {
+		return state, err
+	}
+	mac = cfg.prefixMAC(r, mac, state.Chain)
+	mac.Write(chunk) //nolint:errcheck //<-hash.Hash never returns error
+	sum := mac.Sum(nil)
+	if err = canonicalError(mac); err != nil {
+		return state, err
+	}
+	next := UploadState{Chain: sum, Offset: state.Offset + int64(len(chunk))}
+	setRequestBody(r, chunk)
+	r.Header.Set(uploadIDHeader, id)
+	r.Header.Set(uploadOffsetHeader, strconv.FormatInt(state.Offset, 10))
+	r.Header.Set(hashVarName, formatHash(alg, next.Chain))
+	return next, nil
+}
+
+// UploadStateFromHeader returns state of upload from headers of response with 409 status.
+func UploadStateFromHeader(header http.Header) (UploadState, error) {
+	offset, err := strconv.ParseInt(header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		return UploadState{}, fmt.Errorf("%w: offset '%s'", ErrUploadChunk, header.Get(uploadOffsetHeader))
+	}
+	chain, err := hex.DecodeString(header.Get(uploadChainHeader))
+	if err != nil {
+		return UploadState{}, fmt.Errorf("%w: chain decode error: %w", ErrUploadChunk, err)
+	}
+	return UploadState{Chain: chain, Offset: offset}, nil
+}
+
+// signHash returns hash summ header value of data.
+func signHash(alg string, key []byte, data ...[]byte) (string, error) {
+	mac, err := newHashMAC(alg, key)
+	if err != nil {
+		return "", err
+	}
+	for _, item := range data {
+		mac.Write(item) //nolint:errcheck //<-hash.Hash never returns error
+	}
+	return formatHash(alg, mac.Sum(nil)), nil
+}
+
+// newHashMAC creates HMAC of known algorithm.
+func newHashMAC(alg string, key []byte) (hash.Hash, error) {
+	if _, ok := hashAlgorithms[alg]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrHashAlgorithm, alg)
+	}
+	return hmac.New(hashAlgorithm(alg), key), nil
+}
+
+// uploadChunk returns chunk of request.
+func uploadChunk(r *http.Request) *UploadChunk {
+	chunk, _ := r.Context().Value(HashUpload).(*UploadChunk) //nolint:errcheck //<-nil for other requests
+	return chunk
+}
+
+// isMultipart checks that parts of request are checked.
+func (c *hashConfig) isMultipart(r *http.Request) bool {
+	return c.multipart && strings.HasPrefix(mediaType(r.Header.Get(contentType)), "multipart/")
+}
+
+// checkParts checks hash summs of parts of multipart body.
+func (c *hashConfig) checkParts(r *http.Request, body io.Reader, key []byte) error {
+	_, params, err := mime.ParseMediaType(r.Header.Get(contentType))
+	if err != nil || params["boundary"] == "" {
+		return fmt.Errorf("%w: multipart boundary is missing", ErrHashPart)
+	}
+	reader := multipart.NewReader(body, params["boundary"])
+	for index := 0; ; index++ {
+		part, err := reader.NextRawPart()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrHashPart, err)
+		}
+		if err = c.checkPart(part, key); err != nil {
+			return fmt.Errorf("%w: part %d '%s': %w", ErrHashPart, index, part.FormName(), err)
+		}
+	}
+}
+
+// checkPart checks hash summ of part.
+func (c *hashConfig) checkPart(part *multipart.Part, key []byte) error {
+	hash := part.Header.Get(hashVarName)
+	if hash == "" {
+		if c.strict {
+			return ErrHashMissing
+		}
+		return nil
+	}
+	if err := c.allow(hash); err != nil {
+		return err
+	}
+	alg, sum, err := parseHash(hash)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(hashAlgorithm(alg), key)
+	if _, err = io.Copy(mac, part); err != nil {
+		return getError(ReadBodyError, err)
+	}
+	return compareHash(sum, mac.Sum(nil), hash)
+}
+
+// startUpload loads state of upload and sets chunk to request context.
+// In case of conflict state of upload is set to response headers.
+func (c *hashConfig) startUpload(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	id := r.Header.Get(uploadIDHeader)
+	if c.uploads == nil || id == "" {
+		return r, nil
+	}
+	if len(id) > maxUploadIDLength {
+		return r, fmt.Errorf("%w: upload ID is too long", ErrUploadChunk)
+	}
+	offset, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		return r, fmt.Errorf("%w: offset '%s'", ErrUploadChunk, r.Header.Get(uploadOffsetHeader))
+	}
+	state, err := c.uploads.Load(id)
+	if err != nil {
+		return r, fmt.Errorf("%w: %w", ErrUploadStore, err)
+	}
+	if state.Offset != offset {
+		setUploadHeaders(w.Header(), state)
+		return r, fmt.Errorf("%w: upload '%s' offset is %d, got %d", ErrUploadConflict, id, state.Offset, offset)
+	}
+	chunk := &UploadChunk{ID: id, State: state}
+	return r.WithContext(context.WithValue(r.Context(), HashUpload, chunk)), nil
+}
+
+// reserveUpload stores state of upload after verified chunk before handler is called.
+// In case of conflict state of upload is set to response headers.
+func (c *hashConfig) reserveUpload(w http.ResponseWriter, r *http.Request, vr *verifyingReader) error {
+	chunk := uploadChunk(r)
+	if chunk == nil {
+		return nil
+	}
+	next := UploadState{Chain: vr.sum, Offset: chunk.State.Offset + vr.size}
+	if err := c.uploads.Store(chunk.ID, chunk.State, next); err != nil {
+		if errors.Is(err, ErrUploadConflict) {
+			if state, err := c.uploads.Load(chunk.ID); err == nil {
+				setUploadHeaders(w.Header(), state)
+			}
+			return fmt.Errorf("upload '%s' store error: %w", chunk.ID, err)
+		}
+		return fmt.Errorf("%w: %w", ErrUploadStore, err)
+	}
+	return nil
+}
+
+// releaseUpload restores state of upload before chunk if response status is not 2xx.
+func (c *hashConfig) releaseUpload(r *http.Request, hw *hashWriter, vr *verifyingReader) error {
+	chunk := uploadChunk(r)
+	if chunk == nil || hw.status < http.StatusMultipleChoices {
+		return nil
+	}
+	next := UploadState{Chain: vr.sum, Offset: chunk.State.Offset + vr.size}
+	if err := c.uploads.Store(chunk.ID, next, chunk.State); err != nil {
+		return fmt.Errorf("upload '%s' restore error: %w", chunk.ID, err)
+	}
+	return nil
+}
+
+// setUploadHeaders sets state of upload to headers.
+func setUploadHeaders(header http.Header, state UploadState) {
+	header.Set(uploadOffsetHeader, strconv.FormatInt(state.Offset, 10))
+	header.Set(uploadChainHeader, hex.EncodeToString(state.Chain))
+}
@@ -0,0 +1,264 @@
+package middlewares
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestWithHashMultipart(t *testing.T) {
+	key := []byte("key")
+	form := func(change func(index int, header textproto.MIMEHeader, data []byte) []byte) (string, []byte) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for index, data := range []string{"first part", strings.Repeat("second;", 100)} {
+			header := make(textproto.MIMEHeader)
+			header.Set("Content-Disposition", `form-data; name="file"`)
+			assert.NoError(t, SetPartHash(header, []byte(data), key, HashAlgSHA512))
+			part := []byte(data)
+			if change != nil {
+				part = change(index, header, part)
+			}
+			w, err := mw.CreatePart(header)
+			assert.NoError(t, err)
+			_, err = w.Write(part)
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, mw.Close())
+		return mw.FormDataContentType(), body.Bytes()
+	}
+	tests := []struct {
+		change func(index int, header textproto.MIMEHeader, data []byte) []byte
+		name   string
+		opts   []HashOption
+		status int
+	}{
+		{name: "Valid", status: http.StatusOK},
+		{name: "Valid streaming", opts: []HashOption{WithHashStreaming()}, status: http.StatusOK},
+		{
+			name:   "Changed part",
+			change: func(index int, _ textproto.MIMEHeader, data []byte) []byte { return append(data, byte(index)) },
+			status: http.StatusForbidden,
+		},
+		{
+			name: "Missing part hash",
+			change: func(index int, header textproto.MIMEHeader, data []byte) []byte {
+				if index == 1 {
+					header.Del(hashVarName)
+				}
+				return data
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "Not allowed algorithm",
+			opts:   []HashOption{WithHashAlgorithms(HashAlgSHA256)},
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctype, data := form(tt.change)
+			called := false
+			opts := append([]HashOption{WithHashMultipart(), WithStrictHash()}, tt.opts...)
+			handler := NewHashCheckMiddleware(key, zap.NewNop().Sugar(), opts...)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					called = true
+					body, err := io.ReadAll(r.Body)
+					assert.NoError(t, err)
+					assert.Equal(t, data, body)
+				}))
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+			r.Header.Set(contentType, ctype)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.status == http.StatusOK, called)
+		})
+	}
+}
+
+func TestWithHashUploads(t *testing.T) {
+	key := []byte("key")
+	store := NewMemoryUploadStore(0, 0)
+	var received []byte
+	var duplicate func()
+	status := http.StatusOK
+	handler := NewHashCheckMiddleware(key, zap.NewNop().Sugar(), WithHashUploads(store), WithHashStreaming())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			chunk, ok := r.Context().Value(HashUpload).(*UploadChunk)
+			assert.True(t, ok)
+			assert.Equal(t, "upload", chunk.ID)
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return
+			}
+			if status == http.StatusOK {
+				received = append(received[:chunk.State.Offset], body...)
+			}
+			if duplicate != nil {
+				duplicate()
+			}
+			w.WriteHeader(status)
+		}))
+	send := func(state UploadState, chunk string) (UploadState, *httptest.ResponseRecorder) {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		next, err := SignUploadChunk(r, "upload", state, []byte(chunk), key, HashAlgSHA256)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return next, w
+	}
+
+	first, w := send(UploadState{}, "first;")
+	assert.Equal(t, http.StatusOK, w.Code)
+	second, w := send(first, "second;")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Chunk with the accepted offset again.
+	_, w = send(first, "second;")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	state, err := UploadStateFromHeader(w.Header())
+	assert.NoError(t, err)
+	assert.Equal(t, second, state)
+
+	// Chunk which is not chained from the accepted one.
+	_, w = send(UploadState{Offset: second.Offset}, "third;")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// State is not stored for failed handler.
+	status = http.StatusInternalServerError
+	_, w = send(second, "third;")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	status = http.StatusOK
+	current, err := store.Load("upload")
+	assert.NoError(t, err)
+	assert.Equal(t, second, current)
+
+	// Resume from the stored state. Chunk with the same offset is rejected
+	// while the chunk is handled.
+	duplicate = func() {
+		duplicate = nil
+		_, w := send(current, "third;")
+		assert.Equal(t, http.StatusConflict, w.Code, "concurrent chunk accepted")
+	}
+	third, w := send(current, "third;")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, duplicate, "concurrent chunk is not sent")
+	assert.Equal(t, "first;second;third;", string(received))
+	current, err = store.Load("upload")
+	assert.NoError(t, err)
+	assert.Equal(t, third, current)
+
+	store.Delete("upload")
+	current, err = store.Load("upload")
+	assert.NoError(t, err)
+	assert.Equal(t, UploadState{}, current)
+}
+
+func TestWithHashUploads_headers(t *testing.T) {
+	handler := NewHashCheckMiddleware([]byte("key"), zap.NewNop().Sugar(), WithHashUploads(NewMemoryUploadStore(0, 0)))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tt := range []struct {
+		name   string
+		id     string
+		offset string
+		status int
+	}{
+		{name: "Bad offset", id: "upload", offset: "-1", status: http.StatusBadRequest},
+		{name: "Long ID", id: strings.Repeat("a", maxUploadIDLength+1), offset: "0", status: http.StatusBadRequest},
+		{name: "Missing hash", id: "upload", offset: "0", status: http.StatusBadRequest},
+		{name: "Not upload", status: http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("chunk"))
+			if tt.id != "" {
+				r.Header.Set(uploadIDHeader, tt.id)
+				r.Header.Set(uploadOffsetHeader, tt.offset)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestWithHashUploads_replay(t *testing.T) {
+	key := []byte("key")
+	guard := NewReplayGuard(time.Minute, nil)
+	tests := []struct {
+		name   string
+		server []HashOption
+		client []HashOption
+		status int
+	}{
+		{name: "Guard", server: []HashOption{WithHashReplayGuard(guard)}, client: []HashOption{WithHashReplayGuard(guard)}, status: http.StatusOK},
+		{name: "Without guard", status: http.StatusOK},
+		{name: "Client without guard", server: []HashOption{WithHashReplayGuard(guard)}, status: http.StatusBadRequest},
+		{name: "Server without guard", client: []HashOption{WithHashReplayGuard(guard)}, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]HashOption{WithHashUploads(NewMemoryUploadStore(0, 0))}, tt.server...)
+			handler := NewHashCheckMiddleware(key, zap.NewNop().Sugar(), opts...)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			state := UploadState{}
+			for _, chunk := range []string{"first;", "second;"} {
+				r := httptest.NewRequest(http.MethodPost, "/", nil)
+				params, err := NewReplayParams()
+				assert.NoError(t, err)
+				params.SetHeaders(r.Header)
+				state, err = SignUploadChunk(r, "upload", state, []byte(chunk), key, HashAlgSHA256, tt.client...)
+				assert.NoError(t, err)
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				if assert.Equal(t, tt.status, w.Code, chunk) && tt.status != http.StatusOK {
+					return
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryUploadStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryUploadStore(time.Minute, 2)
+	store.now = func() time.Time { return now }
+	state := func(offset int64) UploadState { return UploadState{Chain: []byte{byte(offset)}, Offset: offset} }
+	assert.NoError(t, store.Store("a", UploadState{}, state(1)))
+	now = now.Add(30 * time.Second)
+	assert.NoError(t, store.Store("b", UploadState{}, state(2)))
+	assert.ErrorIs(t, store.Store("b", UploadState{}, state(3)), ErrUploadConflict)
+
+	// The least recently updated upload is evicted when the store is full.
+	assert.NoError(t, store.Store("a", state(1), state(4)))
+	assert.NoError(t, store.Store("c", UploadState{}, state(5)))
+	assert.Equal(t, 2, store.Len())
+	current, err := store.Load("b")
+	assert.NoError(t, err)
+	assert.Equal(t, UploadState{}, current, "least recently updated upload is kept")
+	current, err = store.Load("a")
+	assert.NoError(t, err)
+	assert.Equal(t, state(4), current)
+
+	// Upload is expired when it is not updated during time to live.
+	now = now.Add(time.Minute)
+	current, err = store.Load("a")
+	assert.NoError(t, err)
+	assert.Equal(t, UploadState{}, current, "expired upload is kept")
+	assert.Zero(t, store.Len())
+	assert.NoError(t, store.Store("a", UploadState{}, state(1)), "expired upload state is not reset")
+
+	store = NewMemoryUploadStore(0, 0)
+	assert.Equal(t, defaultUploadTTL, store.ttl)
+	assert.Equal(t, defaultUploadCapacity, store.capacity)
+}
@@ -0,0 +1,344 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ErrCanonicalJSON is returned when body can't be canonicalized: it is not valid I-JSON
+// (RFC 7493), e.g. contains duplicated keys, unpaired surrogates or numbers out of
+// IEEE 754 double range.
+var ErrCanonicalJSON = errors.New("canonical json error")
+
+// Internal types.
+type (
+	// Object member of JSON value.
+	jsonMember struct {
+		value any
+		key   string
+	}
+	// HMAC of canonical form of JSON data. Data is buffered until Sum, values
+	// written to HMAC before (replay protection values) are kept as is.
+	canonicalMAC struct {
+		hash.Hash
+		buf bytes.Buffer
+		err error
+	}
+)
+
+// CanonicalJSON returns JSON Canonicalization Scheme (RFC 8785) form of data:
+// whitespace is removed, object keys are sorted by UTF-16 code units, strings and
+// numbers are serialized as ECMAScript JSON.stringify does.
+func CanonicalJSON(data []byte) ([]byte, error) {
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("%w: invalid utf-8", ErrCanonicalJSON)
+	}
+	if err := checkSurrogates(data); err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	value, err := decodeJSONValue(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = decoder.Token(); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: data after json value", ErrCanonicalJSON)
+	}
+	var buf bytes.Buffer
+	if err = writeCanonicalJSON(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CanonicalJSONHash returns hash summ header value of canonical form of JSON data, as
+// NewHashCheckMiddleware checks it with WithHashCanonicalJSON option.
+// Algorithm is one of HashAlgSHA256, HashAlgSHA384 or HashAlgSHA512.
+func CanonicalJSONHash(data, key []byte, alg string) (string, error) {
+	if _, ok := hashAlgorithms[alg]; !ok {
+		return "", fmt.Errorf("%w: %s", ErrHashAlgorithm, alg)
+	}
+	canonical, err := CanonicalJSON(data)
+	if err != nil {
+		return "", err
+	}
+	return signHash(alg, key, canonical)
+}
+
+// WithHashCanonicalJSON enables hash summ of canonical form (see CanonicalJSON) of
+// JSON request bodies (application/json and */*+json types), so JSON re-serialized
+// by proxies is accepted. Requests with invalid JSON are rejected with 400 status.
+// Hash summ of responses is calculated for the body as is.
+// JSON bodies are buffered for canonicalization in WithHashStreaming mode too.
+func WithHashCanonicalJSON() HashOption {
+	return func(c *hashConfig) {
+		c.canonical = true
+	}
+}
+
+// isJSONRequest checks that request body is JSON.
+func isJSONRequest(r *http.Request) bool {
+	media := mediaType(r.Header.Get(contentType))
+	return media == applicationJSON || strings.HasSuffix(media, "+json")
+}
+
+// newCanonicalMAC creates HMAC of canonical JSON.
+func newCanonicalMAC(mac hash.Hash) *canonicalMAC {
+	return &canonicalMAC{Hash: mac}
+}
+
+// Write buffers data.
+func (c *canonicalMAC) Write(p []byte) (int, error) {
+	return c.buf.Write(p) //nolint:wrapcheck //<-bytes.Buffer returns nil error
+}
+
+// Sum writes canonical form of buffered data to HMAC and returns the summ.
+// Error of canonicalization is saved, and nil is returned. Sum must be called once.
+func (c *canonicalMAC) Sum(b []byte) []byte {
+	if c.buf.Len() == 0 {
+		return c.Hash.Sum(b)
+	}
+	canonical, err := CanonicalJSON(c.buf.Bytes())
+	if err != nil {
+		c.err = err
+		return nil
+	}
+	c.Hash.Write(canonical) //nolint:errcheck //<-hash.Hash never returns error
+	return c.Hash.Sum(b)
+}
+
+// canonicalError returns canonicalization error of HMAC.
+func canonicalError(mac hash.Hash) error {
+	if c, ok := mac.(*canonicalMAC); ok {
+		return c.err
+	}
+	return nil
+}
+
+// checkSurrogates returns error if strings of JSON data contain escaped unpaired UTF-16
+// surrogate. Decoder replaces it by U+FFFD, so different data would get the same canonical form.
+func checkSurrogates(data []byte) error {
+	inString := false
+	for i := 0; i < len(data); i++ {
+		switch {
+		case data[i] == '"':
+			inString = !inString
+		case data[i] == '\\' && inString:
+			unit, ok := escapedUnit(data, i)
+			if !ok {
+				i++ // Escaped character is skipped.
+				continue
+			}
+			if !utf16.IsSurrogate(unit) {
+				i += 5
+				continue
+			}
+			low, ok := escapedUnit(data, i+6)
+			if unit >= 0xdc00 || !ok || low < 0xdc00 || low > 0xdfff {
+				return fmt.Errorf("%w: unpaired surrogate '%s'", ErrCanonicalJSON, data[i:i+6])
+			}
+			i += 11
+		}
+	}
+	return nil
+}
+
+// escapedUnit returns UTF-16 code unit of "\uXXXX" escape at position i of data.
+func escapedUnit(data []byte, i int) (rune, bool) {
+	if i+6 > len(data) || data[i] != '\\' || data[i+1] != 'u' {
+		return 0, false
+	}
+	unit, err := strconv.ParseUint(string(data[i+2:i+6]), 16, 16)
+	if err != nil {
+		return 0, false
+	}
+	return rune(unit), true
+}
+
+// decodeJSONValue decodes value with duplicated keys check.
+func decodeJSONValue(decoder *json.Decoder) (any, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCanonicalJSON, err)
+	}
+	switch value := token.(type) {
+	case json.Delim:
+		switch value {
+		case '{':
+			return decodeJSONObject(decoder)
+		case '[':
+			list := make([]any, 0)
+			for decoder.More() {
+				item, err := decodeJSONValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, item)
+			}
+			if _, err = decoder.Token(); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrCanonicalJSON, err)
+			}
+			return list, nil
+		default:
+			return nil, fmt.Errorf("%w: unexpected '%v'", ErrCanonicalJSON, value)
+		}
+	default:
+		return value, nil
+	}
+}
+
+// decodeJSONObject decodes object members after '{'.
+func decodeJSONObject(decoder *json.Decoder) ([]jsonMember, error) {
+	members := make([]jsonMember, 0)
+	keys := make(map[string]bool)
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCanonicalJSON, err)
+		}
+		key, ok := token.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: object key expected", ErrCanonicalJSON)
+		}
+		if keys[key] {
+			return nil, fmt.Errorf("%w: duplicated key '%s'", ErrCanonicalJSON, key)
+		}
+		keys[key] = true
+		value, err := decodeJSONValue(decoder)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, jsonMember{key: key, value: value})
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCanonicalJSON, err)
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		return lessUTF16(members[i].key, members[j].key)
+	})
+	return members, nil
+}
+
+// lessUTF16 compares strings by UTF-16 code units.
+func lessUTF16(a, b string) bool {
+	x, y := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if x[i] != y[i] {
+			return x[i] < y[i]
+		}
+	}
+	return len(x) < len(y)
+}
+
+// writeCanonicalJSON writes canonical form of decoded value.
+func writeCanonicalJSON(buf *bytes.Buffer, value any) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case string:
+		writeCanonicalString(buf, v)
+	case json.Number:
+		number, err := canonicalNumber(v)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(number)
+	case []any:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonicalJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case []jsonMember:
+		buf.WriteByte('{')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, item.key)
+			buf.WriteByte(':')
+			if err := writeCanonicalJSON(buf, item.value); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("%w: unexpected value %T", ErrCanonicalJSON, value)
+	}
+	return nil
+}
+
+// writeCanonicalString writes string with minimal escaping.
+func writeCanonicalString(buf *bytes.Buffer, value string) {
+	buf.WriteByte('"')
+	for _, c := range value {
+		switch c {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if c < 0x20 { //nolint:gomnd //<-control characters
+				fmt.Fprintf(buf, `\u%04x`, c)
+			} else {
+				buf.WriteRune(c)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// canonicalNumber returns number serialized as ECMAScript Number.prototype.toString.
+func canonicalNumber(number json.Number) (string, error) {
+	value, err := strconv.ParseFloat(string(number), 64)
+	if err != nil || math.IsInf(value, 0) || math.IsNaN(value) {
+		return "", fmt.Errorf("%w: number %s is out of range", ErrCanonicalJSON, number)
+	}
+	return formatES6Number(value), nil
+}
+
+// formatES6Number formats IEEE 754 double by the shortest round trip digits, with
+// exponent for values less than 1e-6 and not less than 1e21.
+func formatES6Number(value float64) string {
+	if value == 0 {
+		return "0"
+	}
+	abs := math.Abs(value)
+	if abs >= 1e-6 && abs < 1e21 {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	text := strconv.FormatFloat(value, 'e', -1, 64)
+	mantissa, exponent, _ := strings.Cut(text, "e")
+	sign := exponent[:1]
+	exponent = strings.TrimLeft(exponent[1:], "0")
+	return mantissa + "e" + sign + exponent
+}
@@ -0,0 +1,174 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{
+			// RFC 8785 section 3.2.2.
+			name: "RFC 8785 example",
+			data: `{
+  "numbers": [333333333.33333329, 1E30, 4.50,
+              2e-3, 0.000000000000000000000000001],
+  "string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+  "literals": [null, true, false]
+}`,
+			want: `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],` +
+				`"string":"€$\u000f\nA'B\"\\\\\"/"}`,
+		},
+		{
+			// RFC 8785 section 3.2.3.
+			name: "RFC 8785 sorting",
+			data: `{
+  "\u20ac": "Euro Sign",
+  "\r": "Carriage Return",
+  "\ufb33": "Hebrew Letter Dalet With Dagesh",
+  "1": "One",
+  "\ud83d\ude00": "Emoji: Grinning Face",
+  "\u0080": "Control",
+  "\u00f6": "Latin Small Letter O With Diaeresis"
+}`,
+			want: "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\"," +
+				"\"\u00f6\":\"Latin Small Letter O With Diaeresis\",\"\u20ac\":\"Euro Sign\"," +
+				"\"\U0001F600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}",
+		},
+		{name: "Nested", data: ` { "b" : [ {"d":1, "c":{}} ], "a":"" } `, want: `{"a":"","b":[{"c":{},"d":1}]}`},
+		{name: "Duplicated key", data: `{"a":1,"a":2}`, wantErr: true},
+		{name: "Number out of range", data: `[1e400]`, wantErr: true},
+		{name: "Not JSON", data: `{"a":`, wantErr: true},
+		{name: "Data after value", data: `{} {}`, wantErr: true},
+		{name: "Invalid UTF-8", data: "\"\xff\"", wantErr: true},
+		{name: "Surrogate pair", data: `["\uD83D\uDE00","\uFFFD"]`, want: "[\"\U0001F600\",\"\uFFFD\"]"},
+		{name: "Escaped backslash", data: `"\\uD800"`, want: `"\\uD800"`},
+		{name: "Unpaired high surrogate", data: `"\uD800"`, wantErr: true},
+		{name: "High surrogate before character", data: `{"a":"\uD800x"}`, wantErr: true},
+		{name: "High surrogates", data: `"\uD800\uD800"`, wantErr: true},
+		{name: "Unpaired low surrogate", data: `["\uDC00"]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CanonicalJSON([]byte(tt.data))
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrCanonicalJSON)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestFormatES6Number(t *testing.T) {
+	// RFC 8785 appendix B.
+	tests := []struct {
+		want string
+		bits uint64
+	}{
+		{bits: 0x0000000000000000, want: "0"},
+		{bits: 0x8000000000000000, want: "0"},
+		{bits: 0x0000000000000001, want: "5e-324"},
+		{bits: 0x8000000000000001, want: "-5e-324"},
+		{bits: 0x7fefffffffffffff, want: "1.7976931348623157e+308"},
+		{bits: 0xffefffffffffffff, want: "-1.7976931348623157e+308"},
+		{bits: 0x4340000000000000, want: "9007199254740992"},
+		{bits: 0xc340000000000000, want: "-9007199254740992"},
+		{bits: 0x4430000000000000, want: "295147905179352830000"},
+		{bits: 0x44b52d02c7e14af5, want: "9.999999999999997e+22"},
+		{bits: 0x44b52d02c7e14af6, want: "1e+23"},
+		{bits: 0x44b52d02c7e14af7, want: "1.0000000000000001e+23"},
+		{bits: 0x444b1ae4d6e2ef4e, want: "999999999999999700000"},
+		{bits: 0x444b1ae4d6e2ef4f, want: "999999999999999900000"},
+		{bits: 0x444b1ae4d6e2ef50, want: "1e+21"},
+		{bits: 0x3eb0c6f7a0b5ed8c, want: "9.999999999999997e-7"},
+		{bits: 0x3eb0c6f7a0b5ed8d, want: "0.000001"},
+		{bits: 0x41b3de4355555553, want: "333333333.3333332"},
+		{bits: 0x41b3de4355555554, want: "333333333.33333325"},
+		{bits: 0x41b3de4355555555, want: "333333333.3333333"},
+		{bits: 0x41b3de4355555556, want: "333333333.3333334"},
+		{bits: 0x41b3de4355555557, want: "333333333.33333343"},
+		{bits: 0xbecbf647612f3696, want: "-0.0000033333333333333333"},
+		{bits: 0x43143ff3c1cb0959, want: "1424953923781206.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, tt.want, formatES6Number(math.Float64frombits(tt.bits)))
+		})
+	}
+	_, err := canonicalNumber(json.Number("-1e309"))
+	assert.ErrorIs(t, err, ErrCanonicalJSON)
+}
+
+func TestWithHashCanonicalJSON(t *testing.T) {
+	key := []byte("key")
+	signed := `{"value":1.50,"id":"metric"}`
+	hash, err := CanonicalJSONHash([]byte(signed), key, HashAlgSHA512)
+	assert.NoError(t, err)
+	tests := []struct {
+		name   string
+		data   string
+		ctype  string
+		hash   string
+		status int
+	}{
+		{name: "Re-serialized", data: "{\n  \"id\": \"metric\",\n  \"value\": 1.5\n}", ctype: applicationJSON, hash: hash, status: http.StatusOK},
+		{name: "Suffix type", data: `{"id":"metric","value":15e-1}`, ctype: "application/metric+json; charset=utf-8", hash: hash, status: http.StatusOK},
+		{name: "Changed value", data: `{"id":"metric","value":2}`, ctype: applicationJSON, hash: hash, status: http.StatusForbidden},
+		{name: "Not JSON type", data: `{"value":1.5,"id":"metric"}`, ctype: "text/plain", hash: hash, status: http.StatusForbidden},
+		{name: "Invalid JSON", data: `{"id":"metric","id":"metric"}`, ctype: applicationJSON, hash: hash, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			t.Run(tt.name, func(t *testing.T) {
+				opts := []HashOption{WithHashCanonicalJSON(), WithStrictHash()}
+				if stream {
+					opts = append(opts, WithHashStreaming())
+				}
+				handler := NewHashCheckMiddleware(key, zap.NewNop().Sugar(), opts...)(
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						var body bytes.Buffer
+						_, err := body.ReadFrom(r.Body)
+						if err == nil {
+							assert.Equal(t, tt.data, body.String(), "body is not changed")
+						}
+					}))
+				r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.data))
+				r.Header.Set(contentType, tt.ctype)
+				r.Header.Set(hashVarName, tt.hash)
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				assert.Equal(t, tt.status, w.Code)
+			})
+		}
+	}
+}
+
+func TestNewHashTransport_canonicalJSON(t *testing.T) {
+	key := []byte("key")
+	server := httptest.NewServer(NewHashCheckMiddleware(key, zap.NewNop().Sugar(), WithHashCanonicalJSON(), WithStrictHash())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	defer server.Close()
+	client := http.Client{Transport: NewHashTransport(nil, key, WithHashCanonicalJSON(), WithStrictHash())}
+	r, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(`{"b": 1, "a": [true]}`))
+	assert.NoError(t, err)
+	r.Header.Set(contentType, applicationJSON)
+	resp, err := client.Do(r)
+	assert.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,95 @@
+package middlewares
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// signerMethod is JWT signing method for crypto.Signer.
+// Tokens are verified by the standard methods, so they are compatible
+// with tokens signed by private keys in memory.
+type signerMethod struct {
+	verify jwt.SigningMethod
+	hash   crypto.Hash
+	size   int // ECDSA coordinate size.
+}
+
+// newSignerMethod selects JWT signing method according to public key type.
+func newSignerMethod(public crypto.PublicKey) (*signerMethod, error) {
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return &signerMethod{verify: jwt.SigningMethodRS256, hash: crypto.SHA256}, nil
+	case *ecdsa.PublicKey:
+		switch k.Curve.Params().BitSize {
+		case 256: //nolint:gomnd //<-P-256
+			return &signerMethod{verify: jwt.SigningMethodES256, hash: crypto.SHA256, size: 32}, nil
+		case 384: //nolint:gomnd //<-P-384
+			return &signerMethod{verify: jwt.SigningMethodES384, hash: crypto.SHA384, size: 48}, nil
+		case 521: //nolint:gomnd //<-P-521
+			return &signerMethod{verify: jwt.SigningMethodES512, hash: crypto.SHA512, size: 66}, nil
+		}
+	case ed25519.PublicKey:
+		return &signerMethod{verify: jwt.SigningMethodEdDSA}, nil
+	}
+	return nil, fmt.Errorf("unsupported signer public key type: %T", public)
+}
+
+// Alg returns JWT algorithm name.
+func (m *signerMethod) Alg() string {
+	return m.verify.Alg()
+}
+
+// Verify checks signature by the standard method.
+func (m *signerMethod) Verify(signingString, signature string, key interface{}) error {
+	return m.verify.Verify(signingString, signature, key) //nolint:wrapcheck //<-senselessly
+}
+
+// Sign signs string by crypto.Signer.
+func (m *signerMethod) Sign(signingString string, key interface{}) (string, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	digest := []byte(signingString)
+	if m.hash != 0 {
+		h := m.hash.New()
+		h.Write(digest) //nolint:errcheck //<-hash never returns error
+		digest = h.Sum(nil)
+	}
+	sig, err := signer.Sign(rand.Reader, digest, m.hash)
+	if err != nil {
+		return "", fmt.Errorf("signer error: %w", err)
+	}
+	if m.size > 0 {
+		if sig, err = ecdsaRawSignature(sig, m.size); err != nil {
+			return "", err
+		}
+	}
+	return jwt.EncodeSegment(sig), nil
+}
+
+// ecdsaRawSignature converts ASN.1 ECDSA signature to r|s format.
+func ecdsaRawSignature(der []byte, size int) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("ecdsa signature parse error: %w", err)
+	}
+	if sig.R.BitLen() > size*8 || sig.S.BitLen() > size*8 {
+		return nil, errors.New("ecdsa signature is too long")
+	}
+	raw := make([]byte, 2*size) //nolint:gomnd //<-r and s
+	sig.R.FillBytes(raw[:size])
+	sig.S.FillBytes(raw[size:])
+	return raw, nil
+}
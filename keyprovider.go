@@ -0,0 +1,21 @@
+package middlewares
+
+import (
+	"crypto"
+)
+
+// KeyProvider gives access to private keys by key ID without exposing them.
+// The keys may live in another process or in HSM (see keys/agent package).
+type KeyProvider interface {
+	// Decrypter returns decrypter for key ID. Empty key ID means the default key.
+	Decrypter(kid string) (crypto.Decrypter, error)
+	// Signer returns signer for key ID. Empty key ID means the default key.
+	Signer(kid string) (crypto.Signer, error)
+}
+
+// DecrypterLookup converts provider to KeyLookup for JWE mode of DecriptMiddleware.
+func DecrypterLookup(provider KeyProvider) KeyLookup {
+	return func(kid string) (crypto.PrivateKey, error) {
+		return provider.Decrypter(kid)
+	}
+}
@@ -0,0 +1,135 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gostuding/middlewares/keys"
+	"github.com/gostuding/middlewares/keys/agent"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// startKeyAgent starts key-agent with one default key and returns connected client.
+func startKeyAgent(t *testing.T, key crypto.PrivateKey) *agent.Client {
+	t.Helper()
+	ring := keys.NewRing[crypto.PrivateKey]()
+	ring.Add("default", key)
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", path)
+	assert.NoError(t, err, "listen error")
+	srv := agent.NewServer(ring.Lookup)
+	go srv.Serve(l) //nolint:errcheck //<-stopped by Close
+	client, err := agent.Dial(path)
+	assert.NoError(t, err, "dial error")
+	t.Cleanup(func() {
+		client.Close() //nolint:errcheck //<-test cleanup
+		srv.Close()    //nolint:errcheck //<-test cleanup
+	})
+	return client
+}
+
+func TestNewAuthMiddleware(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	for _, key := range []crypto.PrivateKey{rsaKey, ecKey, edKey} {
+		provider := startKeyAgent(t, key)
+		signer, err := provider.Signer("")
+		assert.NoError(t, err)
+		token, err := CreateTokenWithSigner(signer, 60, 7, "agent", "192.0.2.1")
+		assert.NoError(t, err, "create token error")
+
+		var uid any
+		handler := NewAuthMiddleware(zap.NewNop().Sugar(), "/login", provider)(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				uid = r.Context().Value(AuthUID)
+			}))
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("User-Agent", "agent")
+		r.Header.Set(authHeader, token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code, "%T token rejected", key)
+		assert.Equal(t, 7, uid)
+
+		hmacToken, err := CreateToken([]byte("key"), 60, 7, "agent", "192.0.2.1")
+		assert.NoError(t, err)
+		r.Header.Set(authHeader, hmacToken)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "HS256 token accepted")
+	}
+}
+
+// countProvider counts Signer calls.
+type countProvider struct {
+	KeyProvider
+	calls int
+}
+
+// Signer counts calls.
+func (p *countProvider) Signer(kid string) (crypto.Signer, error) {
+	p.calls++
+	return p.KeyProvider.Signer(kid) //nolint:wrapcheck //<-test
+}
+
+func TestNewAuthMiddleware_publicKeyCache(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	provider := &countProvider{KeyProvider: startKeyAgent(t, key)}
+	token, err := CreateTokenWithSigner(key, 60, 7, "agent", "192.0.2.1")
+	assert.NoError(t, err)
+	handler := NewAuthMiddleware(zap.NewNop().Sugar(), "/login", provider)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("User-Agent", "agent")
+		r.Header.Set(authHeader, token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Equal(t, 1, provider.calls, "public key is not cached")
+}
+
+func TestNewDecriptMiddleware_provider(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	provider := startKeyAgent(t, key)
+	msg, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &key.PublicKey, []byte("data"), nil)
+	assert.NoError(t, err)
+	var body []byte
+	handler := NewDecriptMiddleware(zap.NewNop().Sugar(), WithKeyProvider(provider))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err = io.ReadAll(r.Body)
+			assert.NoError(t, err)
+		}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(msg)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []byte("data"), body)
+
+	jwe := encriptJWE(t, map[string]any{"alg": jweAlgRSAOAEP256, "enc": jweEncA256GCM, "kid": "default"},
+		&key.PublicKey, []byte("jwe data"))
+	data, _, err := decriptJWE([]byte(jwe), DecrypterLookup(provider), 0, 0)
+	assert.NoError(t, err, "decript jwe by provider error")
+	assert.Equal(t, []byte("jwe data"), data)
+}
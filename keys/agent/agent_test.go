@@ -0,0 +1,142 @@
+package agent
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func startAgent(t *testing.T, keys map[string]crypto.PrivateKey) (*Server, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", path)
+	assert.NoError(t, err, "listen error")
+	srv := NewServer(func(kid string) (crypto.PrivateKey, error) {
+		if key, ok := keys[kid]; ok {
+			return key, nil
+		}
+		return nil, errors.New("not found")
+	})
+	go srv.Serve(l)                   //nolint:errcheck //<-stopped by Close
+	t.Cleanup(func() { srv.Close() }) //nolint:errcheck //<-test cleanup
+	return srv, path
+}
+
+func TestClient_Signer(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	_, path := startAgent(t, map[string]crypto.PrivateKey{"": rsaKey, "ec": ecKey, "ed": edKey})
+	client, err := Dial(path)
+	assert.NoError(t, err, "dial error")
+	defer client.Close() //nolint:errcheck //<-test
+
+	digest := sha256.Sum256([]byte("data"))
+	signer, err := client.Signer("")
+	assert.NoError(t, err)
+	assert.True(t, rsaKey.PublicKey.Equal(signer.Public()), "public key not equal")
+	sig, err := signer.Sign(nil, digest[:], crypto.SHA256)
+	assert.NoError(t, err)
+	assert.NoError(t, rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, digest[:], sig))
+	sig, err = signer.Sign(nil, digest[:], &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: rsa.PSSSaltLengthEqualsHash})
+	assert.NoError(t, err)
+	assert.NoError(t, rsa.VerifyPSS(&rsaKey.PublicKey, crypto.SHA256, digest[:], sig, nil))
+
+	signer, err = client.Signer("ec")
+	assert.NoError(t, err)
+	sig, err = signer.Sign(nil, digest[:], crypto.SHA256)
+	assert.NoError(t, err)
+	assert.True(t, ecdsa.VerifyASN1(&ecKey.PublicKey, digest[:], sig), "ecdsa signature is not valid")
+
+	signer, err = client.Signer("ed")
+	assert.NoError(t, err)
+	sig, err = signer.Sign(nil, []byte("data"), crypto.Hash(0))
+	assert.NoError(t, err)
+	assert.True(t, ed25519.Verify(edKey.Public().(ed25519.PublicKey), []byte("data"), sig), "ed25519 signature is not valid")
+
+	_, err = client.Signer("none")
+	assert.ErrorIs(t, err, ErrAgent, "unknown key found")
+}
+
+func TestClient_Decrypter(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	_, path := startAgent(t, map[string]crypto.PrivateKey{"k1": key})
+	client, err := Dial(path)
+	assert.NoError(t, err, "dial error")
+	defer client.Close() //nolint:errcheck //<-test
+
+	decrypter, err := client.Decrypter("k1")
+	assert.NoError(t, err)
+	msg, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &key.PublicKey, []byte("data"), []byte("label"))
+	assert.NoError(t, err)
+	data, err := decrypter.Decrypt(nil, msg, &rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte("label")})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+
+	msg, err = rsa.EncryptPKCS1v15(rand.Reader, &key.PublicKey, []byte("data"))
+	assert.NoError(t, err)
+	data, err = decrypter.Decrypt(nil, msg, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+
+	_, err = decrypter.Decrypt(nil, []byte("broken"), &rsa.OAEPOptions{Hash: crypto.SHA256})
+	assert.ErrorIs(t, err, ErrAgent, "broken message decrypted")
+}
+
+func TestClient_reconnect(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	keys := map[string]crypto.PrivateKey{"": key}
+	srv, path := startAgent(t, keys)
+	client, err := Dial(path)
+	assert.NoError(t, err, "dial error")
+	defer client.Close() //nolint:errcheck //<-test
+	assert.NoError(t, srv.Close())
+	_, err = client.Signer("")
+	assert.Error(t, err, "closed agent used")
+
+	l, err := net.Listen("unix", path)
+	assert.NoError(t, err, "listen error")
+	srv = NewServer(func(kid string) (crypto.PrivateKey, error) { return keys[kid], nil })
+	go srv.Serve(l)   //nolint:errcheck //<-stopped by Close
+	defer srv.Close() //nolint:errcheck //<-test
+	_, err = client.Signer("")
+	assert.NoError(t, err, "client not reconnected")
+}
+
+func TestServer_ListenAndServe(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	srv := NewServer(func(kid string) (crypto.PrivateKey, error) { return key, nil })
+	go srv.ListenAndServe(path)       //nolint:errcheck //<-stopped by Close
+	t.Cleanup(func() { srv.Close() }) //nolint:errcheck //<-test cleanup
+	assert.Eventually(t, func() bool {
+		info, err := os.Stat(path)
+		return err == nil && info.Mode().Perm() == socketMode
+	}, 5*time.Second, 10*time.Millisecond, "socket is accessible by other users")
+
+	client, err := Dial(path)
+	assert.NoError(t, err, "dial error")
+	_, err = client.Signer("")
+	assert.NoError(t, err)
+	assert.NoError(t, client.Close())
+	_, err = client.Signer("")
+	assert.ErrorIs(t, err, net.ErrClosed, "closed client reconnected")
+}
@@ -0,0 +1,179 @@
+package agent
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+const maxMessageSize = 1 << 20 // Max size of one protocol message.
+
+var ErrAgent = errors.New("key agent error") // Error returned by key agent.
+
+// Client is a KeyProvider which performs private key operations in key-agent.
+// Client is safe for concurrent use: requests are sent one by one over one connection.
+type Client struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+	path    string
+	mutex   sync.Mutex
+	closed  bool
+}
+
+// Dial connects to key-agent Unix socket.
+func Dial(path string) (*Client, error) {
+	c := &Client{path: path}
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect internal function.
+func (c *Client) connect() error {
+	conn, err := net.Dial("unix", c.path)
+	if err != nil {
+		return fmt.Errorf("key agent dial error: %w", err)
+	}
+	c.conn = conn
+	c.scanner = bufio.NewScanner(conn)
+	c.scanner.Buffer(nil, maxMessageSize)
+	return nil
+}
+
+// Close closes connection. Requests of closed client fail with net.ErrClosed.
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	if err != nil {
+		return fmt.Errorf("key agent close error: %w", err)
+	}
+	return nil
+}
+
+// call sends request and reads response. Connection is restored after I/O errors,
+// but not after Close.
+func (c *Client) call(req *request) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil, fmt.Errorf("key agent client error: %w", net.ErrClosed)
+	}
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.roundTrip(req)
+	if err != nil {
+		c.conn.Close() //nolint:errcheck //<-connection is broken
+		c.conn = nil
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%w: %s", ErrAgent, resp.Error)
+	}
+	return resp.Data, nil
+}
+
+// roundTrip internal function.
+func (c *Client) roundTrip(req *request) (*response, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("key agent request marshal error: %w", err)
+	}
+	if _, err = c.conn.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("key agent write error: %w", err)
+	}
+	if !c.scanner.Scan() {
+		err = c.scanner.Err()
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("key agent read error: %w", err)
+	}
+	var resp response
+	if err = json.Unmarshal(c.scanner.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("key agent response parse error: %w", err)
+	}
+	return &resp, nil
+}
+
+// remoteKey returns key with public part loaded from agent.
+func (c *Client) remoteKey(kid string) (*remoteKey, error) {
+	data, err := c.call(&request{Op: opPublic, Kid: kid})
+	if err != nil {
+		return nil, err
+	}
+	public, err := x509.ParsePKIXPublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("public key parse error: %w", err)
+	}
+	return &remoteKey{client: c, kid: kid, public: public}, nil
+}
+
+// Signer returns signer for key ID.
+func (c *Client) Signer(kid string) (crypto.Signer, error) {
+	return c.remoteKey(kid)
+}
+
+// Decrypter returns decrypter for key ID.
+func (c *Client) Decrypter(kid string) (crypto.Decrypter, error) {
+	return c.remoteKey(kid)
+}
+
+// remoteKey is private key in key-agent.
+type remoteKey struct {
+	public crypto.PublicKey
+	client *Client
+	kid    string
+}
+
+// Public returns public key.
+func (k *remoteKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+// Sign signs digest in key-agent. The rand argument is ignored.
+func (k *remoteKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	req := &request{Op: opSign, Kid: k.kid, Data: digest, Hash: opts.HashFunc()}
+	if pss, ok := opts.(*rsa.PSSOptions); ok {
+		req.PSS = true
+		req.Salt = pss.SaltLength
+	}
+	return k.client.call(req)
+}
+
+// Decrypt decrypts message in key-agent. The rand argument is ignored.
+// Nil opts and *rsa.PKCS1v15DecryptOptions mean PKCS #1 v1.5 decryption.
+func (k *remoteKey) Decrypt(_ io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	req := &request{Op: opDecrypt, Kid: k.kid, Data: msg}
+	switch o := opts.(type) {
+	case nil:
+	case *rsa.PKCS1v15DecryptOptions:
+		if o.SessionKeyLen > 0 {
+			return nil, errors.New("session key decryption is not supported")
+		}
+	case *rsa.OAEPOptions:
+		req.OAEP = true
+		req.Hash = o.Hash
+		req.MGFHash = o.MGFHash
+		req.Label = o.Label
+	default:
+		return nil, fmt.Errorf("unsupported decrypter options type %T", opts)
+	}
+	return k.client.call(req)
+}
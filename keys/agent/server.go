@@ -0,0 +1,215 @@
+// Package agent contains key-agent server and client.
+// The server keeps private keys and performs sign and decrypt operations for
+// clients connected over Unix socket. The client implements KeyProvider of
+// middlewares package, so private keys never enter the process of the client and
+// its middlewares: they live in the agent server process only.
+//
+// Protocol: every request and response is one JSON object per line.
+package agent
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+)
+
+// Operations.
+const (
+	opPublic  = "public"
+	opSign    = "sign"
+	opDecrypt = "decrypt"
+)
+
+const socketMode = 0o600 // Mode of socket created by ListenAndServe.
+
+// Internal types.
+type (
+	// Client request.
+	request struct {
+		Op      string      `json:"op"`
+		Kid     string      `json:"kid"`
+		Data    []byte      `json:"data,omitempty"`
+		Label   []byte      `json:"label,omitempty"`
+		Hash    crypto.Hash `json:"hash,omitempty"`
+		Salt    int         `json:"salt,omitempty"`
+		PSS     bool        `json:"pss,omitempty"`
+		OAEP    bool        `json:"oaep,omitempty"`
+		MGFHash crypto.Hash `json:"mgf_hash,omitempty"`
+	}
+	// Server response.
+	response struct {
+		Error string `json:"error,omitempty"`
+		Data  []byte `json:"data,omitempty"`
+	}
+)
+
+// KeyLookup returns private key by key ID. Empty ID means the default key.
+// Method Lookup of keys.Ring[crypto.PrivateKey] may be used as KeyLookup.
+type KeyLookup func(kid string) (crypto.PrivateKey, error)
+
+// Server is key-agent server.
+type Server struct {
+	keys      KeyLookup
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	mutex     sync.Mutex
+	closed    bool
+}
+
+// NewServer creates key-agent server.
+func NewServer(keys KeyLookup) *Server {
+	return &Server{
+		keys:      keys,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens Unix socket and serves clients.
+// Socket is accessible by the owner only (0600), because any client which
+// connects to it may use the private keys.
+func (s *Server) ListenAndServe(path string) error {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("key agent listen error: %w", err)
+	}
+	if err = os.Chmod(path, socketMode); err != nil {
+		l.Close() //nolint:errcheck //<-socket is not served
+		return fmt.Errorf("key agent socket mode error: %w", err)
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections until listener is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		l.Close() //nolint:errcheck //<-server is closed
+		return net.ErrClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mutex.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			delete(s.listeners, l)
+			if s.closed {
+				return net.ErrClosed
+			}
+			return fmt.Errorf("key agent accept error: %w", err)
+		}
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			conn.Close() //nolint:errcheck //<-server is closed
+			continue
+		}
+		s.conns[conn] = struct{}{}
+		s.mutex.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops server and closes connections.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		err = errors.Join(err, l.Close())
+	}
+	for conn := range s.conns {
+		err = errors.Join(err, conn.Close())
+	}
+	if err != nil {
+		return fmt.Errorf("key agent close error: %w", err)
+	}
+	return nil
+}
+
+// serveConn handles requests of one client.
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+		conn.Close() //nolint:errcheck //<-connection is not used anymore
+	}()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, maxMessageSize)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req request
+		var resp response
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = fmt.Sprintf("request parse error: %v", err)
+		} else if resp.Data, err = s.handle(&req); err != nil {
+			resp.Error = err.Error()
+		}
+		if err := encoder.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// handle performs requested operation.
+func (s *Server) handle(req *request) ([]byte, error) {
+	key, err := s.keys(req.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("key '%s' lookup error: %w", req.Kid, err)
+	}
+	switch req.Op {
+	case opPublic:
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("key '%s' type %T has no public key", req.Kid, key)
+		}
+		data, err := x509.MarshalPKIXPublicKey(signer.Public())
+		if err != nil {
+			return nil, fmt.Errorf("marshal public key error: %w", err)
+		}
+		return data, nil
+	case opSign:
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("key '%s' type %T is not signer", req.Kid, key)
+		}
+		var opts crypto.SignerOpts = req.Hash
+		if req.PSS {
+			opts = &rsa.PSSOptions{SaltLength: req.Salt, Hash: req.Hash}
+		}
+		sig, err := signer.Sign(rand.Reader, req.Data, opts)
+		if err != nil {
+			return nil, fmt.Errorf("sign error: %w", err)
+		}
+		return sig, nil
+	case opDecrypt:
+		decrypter, ok := key.(crypto.Decrypter)
+		if !ok {
+			return nil, fmt.Errorf("key '%s' type %T is not decrypter", req.Kid, key)
+		}
+		var opts crypto.DecrypterOpts
+		if req.OAEP {
+			opts = &rsa.OAEPOptions{Hash: req.Hash, MGFHash: req.MGFHash, Label: req.Label}
+		}
+		data, err := decrypter.Decrypt(rand.Reader, req.Data, opts)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt error: %w", err)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unknown operation '%s'", req.Op)
+	}
+}
@@ -0,0 +1,162 @@
+// Package keys contains helpers for loading private and public keys
+// from PEM files and for hot reload of keys changed on disk.
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// PEM block types.
+const (
+	rsaPrivateKeyType       = "RSA PRIVATE KEY"
+	ecPrivateKeyType        = "EC PRIVATE KEY"
+	privateKeyType          = "PRIVATE KEY"
+	encryptedPrivateKeyType = "ENCRYPTED PRIVATE KEY"
+	publicKeyType           = "PUBLIC KEY"
+	rsaPublicKeyType        = "RSA PUBLIC KEY"
+)
+
+var (
+	ErrNoPEMBlock        = errors.New("pem block not found")        // Data does not contain PEM block.
+	ErrPasswordRequired  = errors.New("key is encrypted")           // Encrypted key without password.
+	ErrUnsupportedKey    = errors.New("unsupported key type")       // Unknown key type.
+	ErrUnsupportedFormat = errors.New("unsupported pem block type") // Unknown PEM block type.
+)
+
+// ParsePrivateKey parses PEM encoded private key.
+// Supported formats are PKCS#1 (RSA), SEC1 (EC) and PKCS#8 (RSA, ECDSA,
+// Ed25519, X25519). Encrypted PKCS#8 and legacy encrypted PEM blocks are
+// decrypted with password.
+// The result is *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey or *ecdh.PrivateKey.
+func ParsePrivateKey(data, password []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNoPEMBlock
+	}
+	der := block.Bytes
+	if x509.IsEncryptedPEMBlock(block) { //nolint:staticcheck //<-legacy format support
+		if len(password) == 0 {
+			return nil, ErrPasswordRequired
+		}
+		var err error
+		der, err = x509.DecryptPEMBlock(block, password) //nolint:staticcheck //<-legacy format support
+		if err != nil {
+			return nil, fmt.Errorf("decrypt pem block error: %w", err)
+		}
+	}
+	switch block.Type {
+	case rsaPrivateKeyType:
+		key, err := x509.ParsePKCS1PrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("parse pkcs1 private key error: %w", err)
+		}
+		return key, nil
+	case ecPrivateKeyType:
+		key, err := x509.ParseECPrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("parse sec1 private key error: %w", err)
+		}
+		return key, nil
+	case encryptedPrivateKeyType:
+		if len(password) == 0 {
+			return nil, ErrPasswordRequired
+		}
+		var err error
+		der, err = decryptPKCS8(der, password)
+		if err != nil {
+			return nil, err
+		}
+		return parsePKCS8(der)
+	case privateKeyType:
+		return parsePKCS8(der)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, block.Type)
+	}
+}
+
+// parsePKCS8 internal function.
+func parsePKCS8(der []byte) (crypto.PrivateKey, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("parse pkcs8 private key error: %w", err)
+	}
+	return key, nil
+}
+
+// ParsePublicKey parses PEM encoded PKIX or PKCS#1 public key.
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNoPEMBlock
+	}
+	switch block.Type {
+	case publicKeyType:
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse pkix public key error: %w", err)
+		}
+		return key, nil
+	case rsaPublicKeyType:
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse pkcs1 public key error: %w", err)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, block.Type)
+	}
+}
+
+// LoadPrivateKey reads and parses private key from file.
+func LoadPrivateKey(path string, password []byte) (crypto.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read private key file error: %w", err)
+	}
+	return ParsePrivateKey(data, password)
+}
+
+// LoadPublicKey reads and parses public key from file.
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read public key file error: %w", err)
+	}
+	return ParsePublicKey(data)
+}
+
+// LoadRSAPrivateKey reads private key from file and checks that it is RSA key.
+func LoadRSAPrivateKey(path string, password []byte) (*rsa.PrivateKey, error) {
+	key, err := LoadPrivateKey(path, password)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T is not rsa key", ErrUnsupportedKey, key)
+	}
+	return rsaKey, nil
+}
+
+// Signer converts private key to crypto.Signer.
+// RSA, ECDSA and Ed25519 keys are supported.
+func Signer(key crypto.PrivateKey) (crypto.Signer, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+	}
+}
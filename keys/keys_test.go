@@ -0,0 +1,144 @@
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadPrivateKey(t *testing.T) {
+	password := []byte("secret")
+	tests := []struct {
+		name     string
+		file     string
+		password []byte
+		wantType any
+		wantErr  bool
+	}{
+		{name: "RSA PKCS#1", file: "rsa_pkcs1.pem", wantType: &rsa.PrivateKey{}},
+		{name: "RSA PKCS#8", file: "rsa_pkcs8.pem", wantType: &rsa.PrivateKey{}},
+		{name: "EC SEC1", file: "ec_sec1.pem", wantType: &ecdsa.PrivateKey{}},
+		{name: "EC PKCS#8", file: "ec_pkcs8.pem", wantType: &ecdsa.PrivateKey{}},
+		{name: "Ed25519 PKCS#8", file: "ed25519_pkcs8.pem", wantType: ed25519.PrivateKey{}},
+		{name: "RSA encrypted PKCS#1", file: "rsa_pkcs1_encrypted.pem", password: password, wantType: &rsa.PrivateKey{}},
+		{name: "RSA encrypted PKCS#8", file: "rsa_pkcs8_encrypted.pem", password: password, wantType: &rsa.PrivateKey{}},
+		{name: "EC encrypted PKCS#8", file: "ec_pkcs8_encrypted.pem", password: password, wantType: &ecdsa.PrivateKey{}},
+		{name: "Encrypted without password", file: "ec_pkcs8_encrypted.pem", wantErr: true},
+		{name: "Incorrect password", file: "ec_pkcs8_encrypted.pem", password: []byte("bad"), wantErr: true},
+		{name: "Public key", file: "rsa_public.pem", wantErr: true},
+		{name: "File not exists", file: "not_exists.pem", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := LoadPrivateKey(filepath.Join("testdata", tt.file), tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadPrivateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && reflect.TypeOf(key) != reflect.TypeOf(tt.wantType) {
+				t.Errorf("LoadPrivateKey() key type = %T, want %T", key, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestLoadPrivateKey_sameKey(t *testing.T) {
+	plain, err := LoadPrivateKey(filepath.Join("testdata", "rsa_pkcs8.pem"), nil)
+	assert.NoError(t, err, "load plain key error")
+	encrypted, err := LoadPrivateKey(filepath.Join("testdata", "rsa_pkcs8_encrypted.pem"), []byte("secret"))
+	assert.NoError(t, err, "load encrypted key error")
+	assert.True(t, plain.(*rsa.PrivateKey).Equal(encrypted), "keys are not equal")
+}
+
+func TestParsePrivateKey_passwordRequired(t *testing.T) {
+	_, err := LoadPrivateKey(filepath.Join("testdata", "rsa_pkcs1_encrypted.pem"), nil)
+	if !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("ParsePrivateKey() error = %v, want %v", err, ErrPasswordRequired)
+	}
+	_, err = ParsePrivateKey([]byte("not pem"), nil)
+	if !errors.Is(err, ErrNoPEMBlock) {
+		t.Errorf("ParsePrivateKey() error = %v, want %v", err, ErrNoPEMBlock)
+	}
+}
+
+func TestLoadPublicKey(t *testing.T) {
+	key, err := LoadPublicKey(filepath.Join("testdata", "rsa_public.pem"))
+	assert.NoError(t, err, "load public key error")
+	private, err := LoadRSAPrivateKey(filepath.Join("testdata", "rsa_pkcs1.pem"), nil)
+	assert.NoError(t, err, "load private key error")
+	assert.True(t, private.PublicKey.Equal(key), "public key is not equal to private")
+}
+
+func TestLoadRSAPrivateKey(t *testing.T) {
+	_, err := LoadRSAPrivateKey(filepath.Join("testdata", "ec_sec1.pem"), nil)
+	if !errors.Is(err, ErrUnsupportedKey) {
+		t.Errorf("LoadRSAPrivateKey() error = %v, want %v", err, ErrUnsupportedKey)
+	}
+}
+
+func TestSigner(t *testing.T) {
+	for _, file := range []string{"rsa_pkcs1.pem", "ec_sec1.pem", "ed25519_pkcs8.pem"} {
+		key, err := LoadPrivateKey(filepath.Join("testdata", file), nil)
+		assert.NoError(t, err, "load key error")
+		_, err = Signer(key)
+		assert.NoError(t, err, "signer error")
+	}
+	_, err := Signer("key")
+	assert.ErrorIs(t, err, ErrUnsupportedKey)
+}
+
+func Test_pbkdf2(t *testing.T) {
+	// RFC 7914 section 11 test vector.
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	got := pbkdf2(sha256.New, []byte("passwd"), []byte("salt"), 1, 64)
+	if hex.EncodeToString(got) != want {
+		t.Errorf("pbkdf2() = %x, want %s", got, want)
+	}
+}
+
+func Test_decryptPKCS8_iterations(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "ec_pkcs8_encrypted.pem"))
+	assert.NoError(t, err, "read file error")
+	block, _ := pem.Decode(data)
+	if !assert.NotNil(t, block, "pem block is nil") {
+		return
+	}
+	// withIterations returns the key encrypted with changed PBKDF2 iteration count.
+	withIterations := func(count int) []byte {
+		var info encryptedPrivateKeyInfo
+		_, err := asn1.Unmarshal(block.Bytes, &info)
+		assert.NoError(t, err)
+		var params pbes2Params
+		_, err = asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params)
+		assert.NoError(t, err)
+		var kdf pbkdf2Params
+		_, err = asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf)
+		assert.NoError(t, err)
+		kdf.IterationCount = count
+		params.KeyDerivationFunc.Parameters.FullBytes, err = asn1.Marshal(kdf)
+		assert.NoError(t, err)
+		info.Algorithm.Parameters.FullBytes, err = asn1.Marshal(params)
+		assert.NoError(t, err)
+		der, err := asn1.Marshal(info)
+		assert.NoError(t, err)
+		return der
+	}
+	for _, count := range []int{0, -1, maxPBKDF2Iterations + 1, 1 << 40} {
+		_, err = decryptPKCS8(withIterations(count), []byte("secret"))
+		assert.ErrorIs(t, err, errDecryptPKCS8, "iteration count %d accepted", count)
+		assert.ErrorContains(t, err, "iteration count", "iteration count %d", count)
+	}
+}
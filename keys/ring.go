@@ -0,0 +1,94 @@
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var ErrKeyNotFound = errors.New("key not found") // Ring does not contain key ID.
+
+// Ring is a set of keys selected by key ID.
+// One of the keys is active and is used for new messages, other keys are
+// retiring: they are still accepted for incoming messages until removed.
+// Ring is safe for concurrent use, so keys may be rotated at runtime.
+type Ring[T any] struct {
+	keys   map[string]T
+	active string
+	mutex  sync.RWMutex
+}
+
+// NewRing creates empty keys ring.
+func NewRing[T any]() *Ring[T] {
+	return &Ring[T]{keys: make(map[string]T)}
+}
+
+// Add adds or replaces the key. The first added key becomes active.
+func (r *Ring[T]) Add(kid string, key T) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.keys) == 0 {
+		r.active = kid
+	}
+	r.keys[kid] = key
+}
+
+// SetActive makes the key active. The previous active key becomes retiring.
+func (r *Ring[T]) SetActive(kid string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.keys[kid]; !ok {
+		return fmt.Errorf("%w: '%s'", ErrKeyNotFound, kid)
+	}
+	r.active = kid
+	return nil
+}
+
+// Remove removes the key from ring. Active key can't be removed.
+func (r *Ring[T]) Remove(kid string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if kid == r.active {
+		return fmt.Errorf("active key '%s' can't be removed", kid)
+	}
+	delete(r.keys, kid)
+	return nil
+}
+
+// Lookup returns key by ID. Empty ID means the active key.
+func (r *Ring[T]) Lookup(kid string) (T, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if kid == "" {
+		kid = r.active
+	}
+	key, ok := r.keys[kid]
+	if !ok {
+		return key, fmt.Errorf("%w: '%s'", ErrKeyNotFound, kid)
+	}
+	return key, nil
+}
+
+// Active returns ID and value of the active key.
+func (r *Ring[T]) Active() (string, T, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	key, ok := r.keys[r.active]
+	if !ok {
+		return "", key, fmt.Errorf("%w: ring is empty", ErrKeyNotFound)
+	}
+	return r.active, key, nil
+}
+
+// IDs returns sorted IDs of all keys in ring.
+func (r *Ring[T]) IDs() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	ids := make([]string, 0, len(r.keys))
+	for kid := range r.keys {
+		ids = append(ids, kid)
+	}
+	sort.Strings(ids)
+	return ids
+}
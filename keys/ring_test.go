@@ -0,0 +1,35 @@
+package keys
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRing(t *testing.T) {
+	ring := NewRing[[]byte]()
+	_, _, err := ring.Active()
+	assert.ErrorIs(t, err, ErrKeyNotFound, "empty ring active key")
+
+	ring.Add("k1", []byte("first"))
+	ring.Add("k2", []byte("second"))
+	kid, key, err := ring.Active()
+	assert.NoError(t, err)
+	assert.Equal(t, "k1", kid, "first key is not active")
+	assert.Equal(t, []byte("first"), key)
+
+	assert.NoError(t, ring.SetActive("k2"), "rotate key error")
+	key, err = ring.Lookup("")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("second"), key, "lookup active key error")
+	key, err = ring.Lookup("k1")
+	assert.NoError(t, err, "retiring key not found")
+	assert.Equal(t, []byte("first"), key)
+
+	assert.Error(t, ring.Remove("k2"), "active key removed")
+	assert.NoError(t, ring.Remove("k1"), "remove retiring key error")
+	_, err = ring.Lookup("k1")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.ErrorIs(t, ring.SetActive("k1"), ErrKeyNotFound)
+	assert.Equal(t, []string{"k2"}, ring.IDs())
+}
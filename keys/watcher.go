@@ -0,0 +1,153 @@
+package keys
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ParseFunc converts file content to the value stored in Watcher.
+type ParseFunc func(data []byte) (any, error)
+
+// Watcher polls file and hot-swaps parsed value when file content changes.
+// Parse errors are reported to error handler, the last good value is kept.
+type Watcher struct {
+	value    atomic.Value
+	parse    ParseFunc
+	onError  func(error)
+	onChange []func(any)
+	path     string
+	interval time.Duration
+	sum      [sha256.Size]byte
+	mutex    sync.Mutex
+}
+
+// NewWatcher creates watcher and loads value from file.
+// The onError func is called for every reload error, it may be nil.
+func NewWatcher(path string, interval time.Duration, parse ParseFunc, onError func(error)) (*Watcher, error) {
+	if interval <= 0 {
+		return nil, errors.New("watcher interval must be positive")
+	}
+	w := &Watcher{path: path, interval: interval, parse: parse, onError: onError}
+	if _, err := w.Reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewKeyWatcher creates watcher for PEM encoded private key.
+func NewKeyWatcher(path string, password []byte, interval time.Duration, onError func(error)) (*Watcher, error) {
+	parse := func(data []byte) (any, error) {
+		return ParsePrivateKey(data, password)
+	}
+	return NewWatcher(path, interval, parse, onError)
+}
+
+// NewSecretWatcher creates watcher for raw secret (e.g. HMAC key).
+// Leading and trailing whitespaces are removed from file content.
+func NewSecretWatcher(path string, interval time.Duration, onError func(error)) (*Watcher, error) {
+	parse := func(data []byte) (any, error) {
+		secret := bytes.TrimSpace(data)
+		if len(secret) == 0 {
+			return nil, errors.New("secret is empty")
+		}
+		return secret, nil
+	}
+	return NewWatcher(path, interval, parse, onError)
+}
+
+// OnChange adds callback which is called after every successful reload.
+func (w *Watcher) OnChange(fn func(any)) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.onChange = append(w.onChange, fn)
+}
+
+// Reload reads file and replaces value if file content changed.
+// Returns true if value was replaced. Callbacks are called without lock,
+// so they may call OnChange and Reload.
+func (w *Watcher) Reload() (bool, error) {
+	value, callbacks, err := w.reload()
+	if err != nil || callbacks == nil {
+		return false, err
+	}
+	for _, fn := range callbacks {
+		fn(value)
+	}
+	return true, nil
+}
+
+// reload replaces value under lock. Returns new value and callbacks to call,
+// callbacks are nil if value is not replaced.
+func (w *Watcher) reload() (any, []func(any), error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("watcher read file error: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	if w.value.Load() != nil && sum == w.sum {
+		return nil, nil, nil
+	}
+	value, err := w.parse(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("watcher parse file '%s' error: %w", w.path, err)
+	}
+	w.value.Store(&value)
+	w.sum = sum
+	callbacks := make([]func(any), len(w.onChange))
+	copy(callbacks, w.onChange)
+	return value, callbacks, nil
+}
+
+// Run polls file until ctx is done.
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := w.Reload(); err != nil && w.onError != nil {
+				w.onError(err)
+			}
+		}
+	}
+}
+
+// Value returns the last good value.
+func (w *Watcher) Value() any {
+	if v, ok := w.value.Load().(*any); ok {
+		return *v
+	}
+	return nil
+}
+
+// PrivateKey returns value as private key.
+func (w *Watcher) PrivateKey() crypto.PrivateKey {
+	return w.Value()
+}
+
+// RSAPrivateKey returns value as RSA private key or nil.
+// Usefull as key func for DecriptMiddlewareFunc.
+func (w *Watcher) RSAPrivateKey() *rsa.PrivateKey {
+	key, _ := w.Value().(*rsa.PrivateKey) //nolint:errcheck //<-nil for other types
+	return key
+}
+
+// Secret returns value as raw secret or nil.
+// Usefull as key func for AuthMiddlewareFunc.
+func (w *Watcher) Secret() []byte {
+	secret, _ := w.Value().([]byte) //nolint:errcheck //<-nil for other types
+	return secret
+}
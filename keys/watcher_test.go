@@ -0,0 +1,113 @@
+package keys
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func copyFile(t *testing.T, from, to string) {
+	t.Helper()
+	data, err := os.ReadFile(from)
+	assert.NoError(t, err, "read file error")
+	assert.NoError(t, os.WriteFile(to, data, 0600), "write file error")
+}
+
+func TestWatcher_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key.pem")
+	copyFile(t, filepath.Join("testdata", "rsa_pkcs1.pem"), path)
+	w, err := NewKeyWatcher(path, nil, time.Second, nil)
+	assert.NoError(t, err, "create watcher error")
+	first := w.RSAPrivateKey()
+	assert.NotNil(t, first, "rsa key is nil")
+
+	changed, err := w.Reload()
+	assert.NoError(t, err, "reload error")
+	assert.False(t, changed, "unchanged file reloaded")
+
+	assert.NoError(t, os.WriteFile(path, []byte("broken"), 0600), "write file error")
+	_, err = w.Reload()
+	assert.Error(t, err, "broken key loaded")
+	assert.Equal(t, first, w.RSAPrivateKey(), "last good key dropped")
+
+	copyFile(t, filepath.Join("testdata", "ec_sec1.pem"), path)
+	changed, err = w.Reload()
+	assert.NoError(t, err, "reload error")
+	assert.True(t, changed, "changed file not reloaded")
+	assert.Nil(t, w.RSAPrivateKey(), "ec key returned as rsa")
+	assert.NotNil(t, w.PrivateKey(), "ec key is nil")
+}
+
+func TestWatcher_Run(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	assert.NoError(t, os.WriteFile(path, []byte("first\n"), 0600), "write file error")
+	errs := make(chan error, 10)
+	w, err := NewSecretWatcher(path, 10*time.Millisecond, func(err error) { errs <- err })
+	assert.NoError(t, err, "create watcher error")
+	assert.Equal(t, []byte("first"), w.Secret())
+	changes := make(chan any, 1)
+	w.OnChange(func(v any) { changes <- v })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	assert.NoError(t, os.WriteFile(path, []byte(" "), 0600), "write file error")
+	select {
+	case err := <-errs:
+		assert.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("reload error not reported")
+	}
+	assert.Equal(t, []byte("first"), w.Secret(), "last good secret dropped")
+
+	assert.NoError(t, os.WriteFile(path, []byte("second"), 0600), "write file error")
+	select {
+	case v := <-changes:
+		assert.Equal(t, []byte("second"), v)
+	case <-time.After(time.Second):
+		t.Fatal("secret not reloaded")
+	}
+	assert.Equal(t, []byte("second"), w.Secret())
+}
+
+func TestNewWatcher(t *testing.T) {
+	_, err := NewSecretWatcher(filepath.Join(t.TempDir(), "none"), time.Second, nil)
+	assert.Error(t, err, "watcher created for missing file")
+	_, err = NewSecretWatcher(filepath.Join("testdata", "rsa_public.pem"), 0, nil)
+	assert.Error(t, err, "watcher created with zero interval")
+}
+
+func TestWatcher_OnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	assert.NoError(t, os.WriteFile(path, []byte("first"), 0600), "write file error")
+	w, err := NewSecretWatcher(path, time.Second, nil)
+	assert.NoError(t, err, "create watcher error")
+	calls := 0
+	w.OnChange(func(any) {
+		calls++
+		// Callback may add callbacks and reload without deadlock.
+		w.OnChange(func(any) {})
+		_, err := w.Reload()
+		assert.NoError(t, err, "reload from callback error")
+	})
+	assert.NoError(t, os.WriteFile(path, []byte("second"), 0600), "write file error")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		changed, err := w.Reload()
+		assert.NoError(t, err, "reload error")
+		assert.True(t, changed, "changed file not reloaded")
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reload deadlock")
+	}
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, []byte("second"), w.Secret())
+}
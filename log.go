@@ -1,6 +1,8 @@
 package middlewares
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"time"
 
@@ -34,7 +36,27 @@ func (r *myLogWriter) Header() http.Header {
 	return r.ResponseWriter.Header()
 }
 
+// ReadFrom sets size of data written by the underlying writer.
+// The underlying io.ReaderFrom is used when it is implemented, so sendfile is kept.
+func (r *myLogWriter) ReadFrom(src io.Reader) (int64, error) {
+	var size int64
+	var err error
+	if rf, ok := r.ResponseWriter.(io.ReaderFrom); ok {
+		size, err = rf.ReadFrom(src)
+	} else {
+		size, err = io.Copy(writerOnly{r.ResponseWriter}, src)
+	}
+	r.Size += int(size)
+	return size, err //nolint:wrapcheck //<-not need
+}
+
+// Unwrap returns the underlying writer.
+func (r *myLogWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 // LoggerMiddleware writes in logger status code and size of responce data.
+// Compression values are written too, when response is compressed by inner compression middleware.
 func LoggerMiddleware(logger *zap.SugaredLogger) func(h http.Handler) http.Handler {
 	var (
 		typeString = "type"
@@ -43,8 +65,10 @@ func LoggerMiddleware(logger *zap.SugaredLogger) func(h http.Handler) http.Handl
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 			rWriter := NewLogWriter(w)
+			stats := &CompressionStats{}
+			r = r.WithContext(context.WithValue(r.Context(), CompressStats, stats))
 			start := time.Now()
-			next.ServeHTTP(rWriter, r)
+			next.ServeHTTP(wrapWriter(rWriter), r)
 			logger.Infow(
 				"Request logger",
 				typeString, "request",
@@ -52,13 +76,21 @@ func LoggerMiddleware(logger *zap.SugaredLogger) func(h http.Handler) http.Handl
 				"method", r.Method,
 				"duration", time.Since(start),
 			)
-			defer logger.Infow(
-				"Response logger",
+			fields := []any{
 				typeString, "responce",
 				urlString, r.RequestURI,
 				"status", rWriter.Status,
 				"size", rWriter.Size,
-			)
+			}
+			if stats.Encoding != "" {
+				fields = append(fields,
+					"encoding", stats.Encoding,
+					"uncompressed_size", stats.Uncompressed,
+					"compression_ratio", stats.Ratio(),
+					"compression_time", stats.Duration,
+				)
+			}
+			defer logger.Infow("Response logger", fields...)
 		}
 		return http.HandlerFunc(fn)
 	}
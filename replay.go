@@ -0,0 +1,209 @@
+package middlewares
+
+import (
+	"container/heap"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	timestampHeader      = "X-Request-Timestamp"
+	nonceHeader          = "X-Request-Nonce"
+	nonceSize            = 16
+	maxNonceLength       = 128
+	defaultNonceCapacity = 100000
+)
+
+var (
+	ErrReplayMissing = errors.New("replay protection values are missing") // Timestamp or nonce is empty.
+	ErrReplayStale   = errors.New("request timestamp is out of window")   // Timestamp is out of skew window.
+	ErrReplayed      = errors.New("request nonce was already used")       // Nonce was seen before.
+)
+
+// NonceStore remembers nonces of accepted requests.
+type NonceStore interface {
+	// Add remembers nonce until expires. Returns false if nonce is already stored.
+	Add(nonce string, expires time.Time) (bool, error)
+}
+
+// Internal types.
+type (
+	// Nonce and its expiration time.
+	nonceItem struct {
+		expires time.Time
+		nonce   string
+	}
+	// Min-heap of nonces by expiration time.
+	nonceHeap []nonceItem
+)
+
+// MemoryNonceStore is a bounded in-memory NonceStore.
+// Expired nonces are removed first. When the store is full of live nonces, the nonce
+// which expires first is evicted, so capacity must be greater than the maximum number
+// of requests during the guard window.
+type MemoryNonceStore struct {
+	items    map[string]bool
+	expires  nonceHeap
+	now      func() time.Time
+	capacity int
+	mutex    sync.Mutex
+}
+
+// NewMemoryNonceStore creates in-memory nonce store. If capacity is not positive,
+// default capacity (100000 nonces) is used.
+func NewMemoryNonceStore(capacity int) *MemoryNonceStore {
+	if capacity <= 0 {
+		capacity = defaultNonceCapacity
+	}
+	return &MemoryNonceStore{
+		items:    make(map[string]bool),
+		now:      time.Now,
+		capacity: capacity,
+	}
+}
+
+// Add remembers nonce until expires.
+func (s *MemoryNonceStore) Add(nonce string, expires time.Time) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	for len(s.expires) > 0 && !s.expires[0].expires.After(now) {
+		s.evict()
+	}
+	if s.items[nonce] {
+		return false, nil
+	}
+	for len(s.expires) >= s.capacity {
+		s.evict()
+	}
+	s.items[nonce] = true
+	heap.Push(&s.expires, nonceItem{nonce: nonce, expires: expires})
+	return true, nil
+}
+
+// evict removes nonce which expires first.
+func (s *MemoryNonceStore) evict() {
+	item := heap.Pop(&s.expires).(nonceItem) //nolint:forcetypeassert //<-only nonceItem in heap
+	delete(s.items, item.nonce)
+}
+
+// Len returns count of stored nonces.
+func (s *MemoryNonceStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.expires)
+}
+
+// Len is heap.Interface method.
+func (h nonceHeap) Len() int { return len(h) }
+
+// Less is heap.Interface method.
+func (h nonceHeap) Less(i, j int) bool { return h[i].expires.Before(h[j].expires) }
+
+// Swap is heap.Interface method.
+func (h nonceHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+// Push is heap.Interface method.
+func (h *nonceHeap) Push(x any) {
+	*h = append(*h, x.(nonceItem)) //nolint:forcetypeassert //<-only nonceItem in heap
+}
+
+// Pop is heap.Interface method.
+func (h *nonceHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// ReplayGuard rejects requests with a timestamp out of skew window and
+// requests with already used nonce.
+// Timestamp (unix seconds) and nonce are sent in "X-Request-Timestamp" and
+// "X-Request-Nonce" headers and must be covered by the request signature or
+// encryption (see NewHashCheckMiddleware and NewDecriptMiddleware).
+type ReplayGuard struct {
+	store  NonceStore
+	now    func() time.Time
+	window time.Duration
+}
+
+// NewReplayGuard creates guard with skew window. If store is nil,
+// MemoryNonceStore is used.
+func NewReplayGuard(window time.Duration, store NonceStore) *ReplayGuard {
+	if store == nil {
+		store = NewMemoryNonceStore(defaultNonceCapacity)
+	}
+	return &ReplayGuard{store: store, window: window, now: time.Now}
+}
+
+// Check checks timestamp and nonce of request.
+func (g *ReplayGuard) Check(timestamp, nonce string) error {
+	if timestamp == "" || nonce == "" {
+		return ErrReplayMissing
+	}
+	if len(nonce) > maxNonceLength {
+		return fmt.Errorf("%w: nonce is too long", ErrReplayMissing)
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: timestamp parse error: %w", ErrReplayStale, err)
+	}
+	ts := time.Unix(seconds, 0)
+	now := g.now()
+	if ts.Before(now.Add(-g.window)) || ts.After(now.Add(g.window)) {
+		return fmt.Errorf("%w: %s", ErrReplayStale, ts.UTC().Format(time.RFC3339))
+	}
+	ok, err := g.store.Add(nonce, ts.Add(g.window))
+	if err != nil {
+		return fmt.Errorf("nonce store error: %w", err)
+	}
+	if !ok {
+		return ErrReplayed
+	}
+	return nil
+}
+
+// ReplayParams are replay protection values of one request.
+type ReplayParams struct {
+	Timestamp string // Unix time in seconds.
+	Nonce     string // Random unique value.
+}
+
+// NewReplayParams creates replay protection values with current time and random nonce.
+func NewReplayParams() (ReplayParams, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return ReplayParams{}, fmt.Errorf("generate nonce error: %w", err)
+	}
+	return ReplayParams{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Nonce:     hex.EncodeToString(nonce),
+	}, nil
+}
+
+// SetHeaders sets replay protection values in request headers.
+func (p ReplayParams) SetHeaders(h http.Header) {
+	h.Set(timestampHeader, p.Timestamp)
+	h.Set(nonceHeader, p.Nonce)
+}
+
+// replayParams returns replay protection values from request headers.
+func replayParams(r *http.Request) ReplayParams {
+	return ReplayParams{Timestamp: r.Header.Get(timestampHeader), Nonce: r.Header.Get(nonceHeader)}
+}
+
+// bytes returns values for signature or additional data.
+// Empty params produce empty value, so messages without replay protection
+// keep the original format.
+func (p ReplayParams) bytes() []byte {
+	if p.Timestamp == "" && p.Nonce == "" {
+		return nil
+	}
+	return []byte(p.Timestamp + "\n" + p.Nonce + "\n")
+}
@@ -0,0 +1,162 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestMemoryNonceStore_Add(t *testing.T) {
+	now := time.Unix(1000, 0)
+	store := NewMemoryNonceStore(2)
+	store.now = func() time.Time { return now }
+	ok, err := store.Add("n1", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, ok, "new nonce rejected")
+	ok, err = store.Add("n1", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, ok, "duplicated nonce accepted")
+
+	_, err = store.Add("n2", now.Add(time.Minute))
+	assert.NoError(t, err)
+	_, err = store.Add("n3", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, store.Len(), "store is not bounded")
+
+	now = now.Add(2 * time.Minute)
+	_, err = store.Add("n4", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, store.Len(), "expired nonces not removed")
+}
+
+func TestMemoryNonceStore_eviction(t *testing.T) {
+	now := time.Unix(1000, 0)
+	store := NewMemoryNonceStore(2)
+	store.now = func() time.Time { return now }
+	// Nonce with old timestamp is added after nonce with new one.
+	_, err := store.Add("late", now.Add(2*time.Minute))
+	assert.NoError(t, err)
+	_, err = store.Add("early", now.Add(time.Second))
+	assert.NoError(t, err)
+
+	now = now.Add(time.Minute)
+	_, err = store.Add("n1", now.Add(30*time.Second))
+	assert.NoError(t, err)
+	ok, err := store.Add("late", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, ok, "live nonce evicted instead of expired one")
+
+	// Full store of live nonces evicts the nonce which expires first.
+	_, err = store.Add("n2", now.Add(time.Minute))
+	assert.NoError(t, err)
+	ok, err = store.Add("late", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, ok, "the latest expiring nonce evicted")
+	assert.Equal(t, 2, store.Len())
+}
+
+func TestNewMemoryNonceStore_capacity(t *testing.T) {
+	for _, capacity := range []int{0, -1} {
+		guard := NewReplayGuard(time.Minute, NewMemoryNonceStore(capacity))
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		assert.NoError(t, guard.Check(ts, "n1"))
+		assert.ErrorIs(t, guard.Check(ts, "n1"), ErrReplayed, "capacity %d", capacity)
+	}
+}
+
+func TestReplayGuard_Check(t *testing.T) {
+	now := time.Unix(1000, 0)
+	store := NewMemoryNonceStore(10)
+	store.now = func() time.Time { return now }
+	guard := NewReplayGuard(time.Minute, store)
+	guard.now = store.now
+	ts := strconv.FormatInt(now.Unix(), 10)
+	tests := []struct {
+		wantErr   error
+		name      string
+		timestamp string
+		nonce     string
+	}{
+		{name: "Correct values", timestamp: ts, nonce: "n1"},
+		{name: "Replayed nonce", timestamp: ts, nonce: "n1", wantErr: ErrReplayed},
+		{name: "Empty nonce", timestamp: ts, wantErr: ErrReplayMissing},
+		{name: "Empty timestamp", nonce: "n2", wantErr: ErrReplayMissing},
+		{name: "Old timestamp", timestamp: "900", nonce: "n3", wantErr: ErrReplayStale},
+		{name: "Future timestamp", timestamp: "1100", nonce: "n4", wantErr: ErrReplayStale},
+		{name: "Incorrect timestamp", timestamp: "now", nonce: "n5", wantErr: ErrReplayStale},
+	}
+	for _, tt := range tests {
+		err := guard.Check(tt.timestamp, tt.nonce)
+		if tt.wantErr == nil {
+			assert.NoError(t, err, tt.name)
+		} else {
+			assert.ErrorIs(t, err, tt.wantErr, tt.name)
+		}
+	}
+}
+
+func TestNewHashCheckMiddleware_replay(t *testing.T) {
+	key := []byte("key")
+	body := []byte("data")
+	handler := NewHashCheckMiddleware(key, zap.NewNop().Sugar(), WithHashReplayGuard(NewReplayGuard(time.Minute, nil)))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	)
+	params, err := NewReplayParams()
+	assert.NoError(t, err)
+	h := hmac.New(sha256.New, key)
+	_, err = h.Write(append(params.bytes(), body...))
+	assert.NoError(t, err)
+	sign := hex.EncodeToString(h.Sum(nil))
+	send := func(hash string) int {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		params.SetHeaders(r.Header)
+		r.Header.Set(hashVarName, hash)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, send(sign), "signed request rejected")
+	assert.Equal(t, http.StatusBadRequest, send(sign), "replayed request accepted")
+	assert.Equal(t, http.StatusBadRequest, send(""), "unsigned request accepted")
+}
+
+func TestNewDecriptMiddleware_replay(t *testing.T) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	enc, err := NewX25519Encryptor("", key.PublicKey())
+	assert.NoError(t, err)
+	handler := NewDecriptMiddleware(
+		zap.NewNop().Sugar(),
+		WithX25519(testKeyLookup(map[string]crypto.PrivateKey{"": key})),
+		WithDecriptReplayGuard(NewReplayGuard(time.Minute, nil)),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	params, err := NewReplayParams()
+	assert.NoError(t, err)
+	msg, err := enc.EncryptWithReplay([]byte("data"), params)
+	assert.NoError(t, err)
+	send := func(params ReplayParams) int {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(msg))
+		r.Header.Set(contentType, enc.ContentType())
+		params.SetHeaders(r.Header)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, send(params), "encripted request rejected")
+	assert.Equal(t, http.StatusBadRequest, send(params), "replayed request accepted")
+	changed := params
+	changed.Nonce = "other"
+	assert.Equal(t, http.StatusBadRequest, send(changed), "request with changed nonce accepted")
+}
@@ -0,0 +1,464 @@
+package middlewares
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	signatureHeader       = "Signature"
+	signatureInputHeader  = "Signature-Input"
+	signatureParams       = "@signature-params"
+	defaultSignatureLabel = "sig1"
+	signatureClockSkew    = time.Minute
+
+	SignatureHMACSHA256 = "hmac-sha256"       // HMAC using SHA-256, key is []byte.
+	SignatureEd25519    = "ed25519"           // EdDSA using curve edwards25519.
+	SignatureECDSAP256  = "ecdsa-p256-sha256" // ECDSA using curve P-256 and SHA-256.
+)
+
+// Message signature errors.
+var (
+	ErrSignatureMissing   = errors.New("message signature is missing")         // Request has not Signature-Input or Signature.
+	ErrSignatureInvalid   = errors.New("message signature is invalid")         // Signature is malformed or not verified.
+	ErrSignatureExpired   = errors.New("message signature is expired")         // Signature is expired, too old or created in future.
+	ErrSignatureKey       = errors.New("message signature key is unsupported") // Key type is not supported.
+	ErrSignatureComponent = errors.New("message component error")              // Covered component is missing or not supported.
+)
+
+// Default covered components.
+var defaultSignatureComponents = []string{"@method", "@authority", "@path", "@query"}
+
+// SignatureKeyLookup returns verification key by key ID: []byte for HMAC-SHA256,
+// ed25519.PublicKey, *ecdsa.PublicKey or crypto.Signer.
+// Method Lookup of keys.Ring[crypto.PublicKey] may be used as SignatureKeyLookup.
+type SignatureKeyLookup func(kid string) (crypto.PublicKey, error)
+
+// Internal types.
+type (
+	// Message signature settings.
+	signatureConfig struct {
+		now        func() time.Time
+		label      string
+		components []string
+		lifetime   time.Duration
+		maxAge     time.Duration
+	}
+	// Client side request signing.
+	signatureTransport struct {
+		base   http.RoundTripper
+		signer *MessageSigner
+	}
+)
+
+// SignatureOption sets message signature settings.
+type SignatureOption func(*signatureConfig)
+
+// WithSignatureComponents sets covered components: derived components ("@method",
+// "@target-uri", "@authority", "@scheme", "@request-target", "@path", "@query") and
+// header field names. Signer covers the components, and verifier requires them to be covered.
+// Default components are "@method", "@authority", "@path" and "@query".
+// Body is protected when "content-digest" or "hashsha256" header is covered.
+func WithSignatureComponents(components ...string) SignatureOption {
+	return func(c *signatureConfig) {
+		c.components = make([]string, 0, len(components))
+		for _, item := range components {
+			c.components = append(c.components, strings.ToLower(item))
+		}
+	}
+}
+
+// WithSignatureLabel sets label of signature. Default label of signer is "sig1",
+// verifier checks the first signature of request if label is not set.
+func WithSignatureLabel(label string) SignatureOption {
+	return func(c *signatureConfig) {
+		c.label = label
+	}
+}
+
+// WithSignatureLifetime sets "expires" parameter of signatures as created time plus lifetime.
+func WithSignatureLifetime(lifetime time.Duration) SignatureOption {
+	return func(c *signatureConfig) {
+		c.lifetime = lifetime
+	}
+}
+
+// WithSignatureMaxAge sets max age of signatures accepted by verifier.
+// Signatures without "created" parameter are rejected if max age is set.
+// Default value is 5 minutes, 0 disables the check.
+func WithSignatureMaxAge(maxAge time.Duration) SignatureOption {
+	return func(c *signatureConfig) {
+		c.maxAge = maxAge
+	}
+}
+
+// newSignatureConfig creates settings with default values.
+func newSignatureConfig(opts ...SignatureOption) *signatureConfig {
+	cfg := &signatureConfig{
+		now:        time.Now,
+		components: defaultSignatureComponents,
+		maxAge:     5 * time.Minute, //nolint:gomnd //<-default value
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// MessageSigner signs requests by HTTP Message Signatures (RFC 9421).
+type MessageSigner struct {
+	key   any
+	cfg   *signatureConfig
+	keyID string
+	alg   string
+}
+
+// NewMessageSigner creates signer. Key is []byte for HMAC-SHA256 or crypto.Signer
+// with Ed25519 or ECDSA P-256 key (see keys.Signer).
+func NewMessageSigner(keyID string, key any, opts ...SignatureOption) (*MessageSigner, error) {
+	if _, ok := key.([]byte); !ok {
+		if _, ok = key.(crypto.Signer); !ok {
+			return nil, fmt.Errorf("%w: %T", ErrSignatureKey, key)
+		}
+	}
+	alg, err := signatureAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+	return &MessageSigner{key: key, keyID: keyID, alg: alg, cfg: newSignatureConfig(opts...)}, nil
+}
+
+// Sign sets Signature-Input and Signature headers of request.
+func (s *MessageSigner) Sign(r *http.Request) error {
+	created := s.cfg.now().Unix()
+	params := []sfParam{{name: "created", value: created}}
+	if s.cfg.lifetime > 0 {
+		params = append(params, sfParam{name: "expires", value: created + int64(s.cfg.lifetime/time.Second)})
+	}
+	params = append(params, sfParam{name: "keyid", value: s.keyID}, sfParam{name: "alg", value: s.alg})
+	list := make([]sfItem, 0, len(s.cfg.components))
+	for _, item := range s.cfg.components {
+		list = append(list, sfItem{value: item})
+	}
+	input := sfItem{value: list, params: params}
+	base, err := signatureBase(r, input)
+	if err != nil {
+		return err
+	}
+	sig, err := s.sign(base)
+	if err != nil {
+		return err
+	}
+	label := s.cfg.label
+	if label == "" {
+		label = defaultSignatureLabel
+	}
+	r.Header.Set(signatureInputHeader, label+"="+serializeSFItem(input))
+	r.Header.Set(signatureHeader, label+"="+serializeSFBareItem(sig))
+	return nil
+}
+
+// sign signs signature base.
+func (s *MessageSigner) sign(base []byte) ([]byte, error) {
+	if key, ok := s.key.([]byte); ok {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(base) //nolint:errcheck //<-hash.Hash never returns error
+		return mac.Sum(nil), nil
+	}
+	signer := s.key.(crypto.Signer) //nolint:forcetypeassert //<-checked by constructor
+	if s.alg == SignatureEd25519 {
+		sig, err := signer.Sign(rand.Reader, base, crypto.Hash(0))
+		if err != nil {
+			return nil, fmt.Errorf("ed25519 sign error: %w", err)
+		}
+		return sig, nil
+	}
+	digest := sha256.Sum256(base)
+	der, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("ecdsa sign error: %w", err)
+	}
+	return ecdsaRawSignature(der, 32) //nolint:gomnd //<-P-256 size
+}
+
+// signatureAlgorithm returns algorithm name for signing or verification key.
+func signatureAlgorithm(key any) (string, error) {
+	switch k := key.(type) {
+	case []byte:
+		return SignatureHMACSHA256, nil
+	case ed25519.PublicKey:
+		return SignatureEd25519, nil
+	case *ecdsa.PublicKey:
+		if k.Curve == elliptic.P256() {
+			return SignatureECDSAP256, nil
+		}
+	case crypto.Signer:
+		return signatureAlgorithm(k.Public())
+	}
+	return "", fmt.Errorf("%w: %T", ErrSignatureKey, key)
+}
+
+// verifySignature verifies signature of signature base by key.
+func verifySignature(key any, base, sig []byte) bool {
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write(base) //nolint:errcheck //<-hash.Hash never returns error
+		return hmac.Equal(sig, mac.Sum(nil))
+	case ed25519.PublicKey:
+		return len(k) == ed25519.PublicKeySize && ed25519.Verify(k, base, sig)
+	case *ecdsa.PublicKey:
+		if len(sig) != 64 { //nolint:gomnd //<-P-256 r and s
+			return false
+		}
+		digest := sha256.Sum256(base)
+		return ecdsa.Verify(k, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	case crypto.Signer:
+		return verifySignature(k.Public(), base, sig)
+	default:
+		return false
+	}
+}
+
+// signatureBase creates signature base for covered components of input.
+func signatureBase(r *http.Request, input sfItem) ([]byte, error) {
+	list, ok := input.value.([]sfItem)
+	if !ok {
+		return nil, fmt.Errorf("%w: inner list expected", ErrSignatureInvalid)
+	}
+	var b strings.Builder
+	for _, item := range list {
+		name, ok := item.value.(string)
+		if !ok || len(item.params) > 0 {
+			return nil, fmt.Errorf("%w: %s", ErrSignatureComponent, serializeSFItem(item))
+		}
+		value, err := componentValue(r, name)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(serializeSFBareItem(name) + ": " + value + "\n")
+	}
+	b.WriteString(serializeSFBareItem(signatureParams) + ": " + serializeSFItem(input))
+	return []byte(b.String()), nil
+}
+
+// componentValue returns value of derived component or header field.
+func componentValue(r *http.Request, name string) (string, error) {
+	switch name {
+	case "@method":
+		return r.Method, nil
+	case "@target-uri":
+		return requestScheme(r) + "://" + requestAuthority(r) + r.URL.RequestURI(), nil
+	case "@authority":
+		return requestAuthority(r), nil
+	case "@scheme":
+		return requestScheme(r), nil
+	case "@request-target":
+		return r.URL.RequestURI(), nil
+	case "@path":
+		if path := r.URL.EscapedPath(); path != "" {
+			return path, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + r.URL.RawQuery, nil
+	}
+	if strings.HasPrefix(name, "@") || name != strings.ToLower(name) {
+		return "", fmt.Errorf("%w: '%s' is not supported", ErrSignatureComponent, name)
+	}
+	values := r.Header.Values(name)
+	if strings.EqualFold(name, "host") && len(values) == 0 && r.Host != "" {
+		values = []string{r.Host}
+	}
+	if len(values) == 0 {
+		return "", fmt.Errorf("%w: header '%s' is missing", ErrSignatureComponent, name)
+	}
+	list := make([]string, 0, len(values))
+	for _, value := range values {
+		list = append(list, strings.TrimSpace(value))
+	}
+	return strings.Join(list, ", "), nil
+}
+
+// requestScheme returns scheme of request on client or server side.
+func requestScheme(r *http.Request) string {
+	if r.URL.Scheme != "" {
+		return strings.ToLower(r.URL.Scheme)
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// requestAuthority returns lowercased host of request without default port.
+func requestAuthority(r *http.Request) string {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	host = strings.ToLower(host)
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		scheme := requestScheme(r)
+		if scheme == "http" && port == "80" || scheme == "https" && port == "443" {
+			return h
+		}
+	}
+	return host
+}
+
+// verify checks request signature and returns key ID of signature.
+func (c *signatureConfig) verify(r *http.Request, keys SignatureKeyLookup) (string, error) {
+	inputs, err := parseSFDictionary(strings.Join(r.Header.Values(signatureInputHeader), ", "))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrSignatureInvalid, err)
+	}
+	sigs, err := parseSFDictionary(strings.Join(r.Header.Values(signatureHeader), ", "))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrSignatureInvalid, err)
+	}
+	label := c.label
+	if label == "" && len(inputs) > 0 {
+		label = inputs[0].name
+	}
+	input, ok := lookupSFMember(inputs, label)
+	if !ok {
+		return "", ErrSignatureMissing
+	}
+	item, ok := lookupSFMember(sigs, label)
+	if !ok {
+		return "", ErrSignatureMissing
+	}
+	sig, ok := item.value.([]byte)
+	if !ok {
+		return "", fmt.Errorf("%w: byte sequence expected", ErrSignatureInvalid)
+	}
+	if err = c.checkInput(input); err != nil {
+		return "", err
+	}
+	keyID, alg := "", ""
+	for _, param := range input.params {
+		switch param.name {
+		case "keyid":
+			keyID, _ = param.value.(string)
+		case "alg":
+			alg, _ = param.value.(string)
+		}
+	}
+	key, err := keys(keyID)
+	if err != nil {
+		return "", fmt.Errorf("%w: key '%s' error: %w", ErrSignatureInvalid, keyID, err)
+	}
+	keyAlg, err := signatureAlgorithm(key)
+	if err != nil {
+		return "", err
+	}
+	if alg != "" && alg != keyAlg {
+		return "", fmt.Errorf("%w: algorithm '%s' is not allowed for key '%s'", ErrSignatureInvalid, alg, keyID)
+	}
+	base, err := signatureBase(r, input)
+	if err != nil {
+		return "", err
+	}
+	if !verifySignature(key, base, sig) {
+		return "", fmt.Errorf("%w: signature of key '%s' is not verified", ErrSignatureInvalid, keyID)
+	}
+	return keyID, nil
+}
+
+// checkInput checks covered components and time parameters of signature.
+func (c *signatureConfig) checkInput(input sfItem) error {
+	list, ok := input.value.([]sfItem)
+	if !ok {
+		return fmt.Errorf("%w: inner list expected", ErrSignatureInvalid)
+	}
+	covered := make(map[any]bool, len(list))
+	for _, item := range list {
+		covered[item.value] = true
+	}
+	for _, name := range c.components {
+		if !covered[name] {
+			return fmt.Errorf("%w: component '%s' is not covered", ErrSignatureInvalid, name)
+		}
+	}
+	now := c.now().Unix()
+	var created int64
+	for _, param := range input.params {
+		value, ok := param.value.(int64)
+		switch param.name {
+		case "created":
+			if !ok || value > now+int64(signatureClockSkew/time.Second) {
+				return fmt.Errorf("%w: incorrect created time", ErrSignatureExpired)
+			}
+			created = value
+		case "expires":
+			if !ok || value < now {
+				return ErrSignatureExpired
+			}
+		}
+	}
+	if c.maxAge > 0 && (created == 0 || now-created > int64(c.maxAge/time.Second)) {
+		return fmt.Errorf("%w: signature is too old", ErrSignatureExpired)
+	}
+	return nil
+}
+
+// NewSignatureMiddleware checks HTTP Message Signatures (RFC 9421) of requests.
+// Verification key is found by "keyid" parameter of signature, and the signature must cover
+// components set by WithSignatureComponents. Requests with missing or invalid signatures are
+// rejected with 401 status. Key ID of the signature is set to request context (SignatureKeyID).
+func NewSignatureMiddleware(
+	keys SignatureKeyLookup,
+	logger *zap.SugaredLogger,
+	opts ...SignatureOption,
+) func(h http.Handler) http.Handler {
+	cfg := newSignatureConfig(opts...)
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			keyID, err := cfg.verify(r, keys)
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				logger.Warnf("message signature error: %v", err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), SignatureKeyID, keyID)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// NewSignatureTransport creates http.RoundTripper which signs requests by signer.
+// If base is nil, http.DefaultTransport is used.
+func NewSignatureTransport(base http.RoundTripper, signer *MessageSigner) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &signatureTransport{base: base, signer: signer}
+}
+
+// RoundTrip signs request.
+func (t *signatureTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	req := r.Clone(r.Context())
+	if err := t.signer.Sign(req); err != nil {
+		if r.Body != nil {
+			r.Body.Close() //nolint:errcheck //<-request is not sent
+		}
+		return nil, fmt.Errorf("sign request error: %w", err)
+	}
+	return t.base.RoundTrip(req) //nolint:wrapcheck //<-senselessly
+}
@@ -0,0 +1,203 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const indexFile = "index.html"
+
+// Internal types.
+type (
+	// Static files handler.
+	staticHandler struct {
+		fsys   fs.FS
+		logger *zap.SugaredLogger
+		cfg    *compressConfig
+		etags  sync.Map
+	}
+	// Cached entity tag of file.
+	fileTag struct {
+		modTime time.Time
+		etag    string
+		size    int64
+	}
+)
+
+// NewStaticHandler creates handler which serves files of fsys (os.DirFS, embed.FS, etc.).
+// When client accepts one of encoders set by WithEncoders and precompressed sidecar file
+// exists next to the original one (e.g. "app.js.gz" for gzip, "app.js.br" for br), the
+// sidecar is served with Content-Encoding header. Otherwise the file is compressed on the fly
+// as by NewCompressMiddleware. Range and conditional requests are supported.
+// Directory requests are served by "index.html" file of the directory.
+func NewStaticHandler(fsys fs.FS, logger *zap.SugaredLogger, opts ...CompressOption) http.Handler {
+	return &staticHandler{fsys: fsys, logger: logger, cfg: newCompressConfig(opts...)}
+}
+
+// ServeHTTP serves static file.
+func (h *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	name, info, err := h.lookup(r.URL.Path)
+	if err != nil {
+		w.WriteHeader(fileErrorStatus(err))
+		h.logger.Warnf("static file '%s' error: %v", r.URL.Path, err)
+		return
+	}
+	etag, err := h.etag(name, info)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.logger.Warnf("static file etag error: %v", err)
+		return
+	}
+	fileType := mime.TypeByExtension(path.Ext(name))
+	if fileType != "" {
+		w.Header().Set(contentType, fileType)
+	}
+	addVary(w.Header(), acceptEncoding)
+	accept := r.Header.Get(acceptEncoding)
+	if encoder, ok := lookupEncoder(negotiateEncoding(accept, h.sidecars(name, fileType))); ok {
+		w.Header().Set(contentEncoding, encoder.Encoding())
+		w.Header().Set(etagHeader, strings.TrimSuffix(etag, `"`)+"-"+encoder.Encoding()+`"`)
+		if err = h.serveFile(w, r, name+encoderExtension(encoder.Encoder)); err == nil {
+			return
+		}
+		h.logger.Warnf("static sidecar file error: %v", err)
+		w.Header().Del(contentEncoding)
+	}
+	w.Header().Set(etagHeader, etag)
+	encoder, ok := lookupEncoder(negotiateEncoding(accept, h.cfg.encoders))
+	if !ok || r.Method == http.MethodHead {
+		if err = h.serveFile(w, r, name); err != nil {
+			h.logger.Warnf("static file error: %v", err)
+		}
+		return
+	}
+	r, stats := h.cfg.withStats(r)
+	cw := newCompressWriter(w, h.logger, h.cfg, encoder)
+	cw.stats = stats
+	if err = h.serveFile(wrapWriter(cw), r, name); err != nil {
+		h.logger.Warnf("static file error: %v", err)
+	}
+	if err = cw.Close(); err != nil {
+		h.logger.Warnf(err.Error())
+	}
+	h.cfg.report(r, stats)
+}
+
+// lookup returns file name and info for URL path.
+func (h *staticHandler) lookup(urlPath string) (string, fs.FileInfo, error) {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		name = "."
+	}
+	info, err := fs.Stat(h.fsys, name)
+	if err != nil {
+		return "", nil, fmt.Errorf("stat error: %w", err)
+	}
+	if info.IsDir() {
+		name = path.Join(name, indexFile)
+		if info, err = fs.Stat(h.fsys, name); err != nil {
+			return "", nil, fmt.Errorf("stat index error: %w", err)
+		}
+	}
+	if !info.Mode().IsRegular() {
+		return "", nil, fmt.Errorf("'%s' is not regular file: %w", name, fs.ErrNotExist)
+	}
+	return name, info, nil
+}
+
+// etag returns strong entity tag of file content. Tags are cached while
+// modification time and size of file are not changed.
+func (h *staticHandler) etag(name string, info fs.FileInfo) (string, error) {
+	if item, ok := h.etags.Load(name); ok {
+		tag := item.(fileTag) //nolint:forcetypeassert //<-only fileTag is stored
+		if tag.size == info.Size() && tag.modTime.Equal(info.ModTime()) {
+			return tag.etag, nil
+		}
+	}
+	file, err := h.fsys.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("open file error: %w", err)
+	}
+	defer file.Close() //nolint:errcheck //<-read only
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("read file error: %w", err)
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	h.etags.Store(name, fileTag{modTime: info.ModTime(), etag: etag, size: info.Size()})
+	return etag, nil
+}
+
+// sidecars returns encoders which have precompressed file for name.
+// Files of unknown media type are not served precompressed, because their
+// type can't be detected by compressed content.
+func (h *staticHandler) sidecars(name, fileType string) []string {
+	if fileType == "" {
+		return nil
+	}
+	names := make([]string, 0, len(h.cfg.encoders))
+	for _, item := range h.cfg.encoders {
+		encoder, ok := lookupEncoder(item)
+		if !ok {
+			continue
+		}
+		info, err := fs.Stat(h.fsys, name+encoderExtension(encoder.Encoder))
+		if err == nil && info.Mode().IsRegular() {
+			names = append(names, item)
+		}
+	}
+	return names
+}
+
+// serveFile writes file content by http.ServeContent.
+func (h *staticHandler) serveFile(w http.ResponseWriter, r *http.Request, name string) error {
+	file, err := h.fsys.Open(name)
+	if err != nil {
+		return fmt.Errorf("open file error: %w", err)
+	}
+	defer file.Close() //nolint:errcheck //<-read only
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat file error: %w", err)
+	}
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return fmt.Errorf("read file error: %w", err)
+		}
+		content = bytes.NewReader(data)
+	}
+	http.ServeContent(w, r, name, info.ModTime(), content)
+	return nil
+}
+
+// fileErrorStatus returns response status for file error.
+func fileErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrInvalid):
+		return http.StatusNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,95 @@
+package middlewares
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestNewStaticHandler(t *testing.T) {
+	script := strings.Repeat("console.log('dashboard');\n", 100)
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"app.js":     {Data: []byte(script), ModTime: modTime},
+		"app.js.gz":  {Data: gzipData(t, []byte(script)), ModTime: modTime},
+		"style.css":  {Data: []byte(strings.Repeat("body { margin: 0; }\n", 100)), ModTime: modTime},
+		"index.html": {Data: []byte("<html></html>"), ModTime: modTime},
+		"logo.png":   {Data: []byte("\x89PNG\x0D\x0A\x1A\x0A"), ModTime: modTime},
+	}
+	handler := NewStaticHandler(fsys, zap.NewNop().Sugar())
+	serve := func(method, target, accept string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		if accept != "" {
+			r.Header.Set(acceptEncoding, accept)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	unzip := func(t *testing.T, w *httptest.ResponseRecorder) string {
+		t.Helper()
+		reader, err := gzip.NewReader(w.Body)
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		data, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("Sidecar file", func(t *testing.T) {
+		w := serve(http.MethodGet, "/app.js", "gzip, deflate")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, gzipString, w.Header().Get(contentEncoding))
+		assert.Equal(t, acceptEncoding, w.Header().Get(varyHeader))
+		assert.Contains(t, w.Header().Get(contentType), "javascript")
+		assert.Equal(t, fsys["app.js.gz"].Data, w.Body.Bytes(), "sidecar is not served as is")
+		etag := w.Header().Get(etagHeader)
+		assert.True(t, strings.HasSuffix(etag, `-gzip"`), "sidecar etag: %s", etag)
+
+		w = serve(http.MethodGet, "/app.js", gzipString, "If-None-Match", etag)
+		assert.Equal(t, http.StatusNotModified, w.Code)
+	})
+	t.Run("Identity", func(t *testing.T) {
+		w := serve(http.MethodGet, "/app.js", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get(contentEncoding))
+		assert.Equal(t, acceptEncoding, w.Header().Get(varyHeader))
+		assert.Equal(t, script, w.Body.String())
+		assert.NotContains(t, w.Header().Get(etagHeader), "-gzip")
+	})
+	t.Run("On the fly", func(t *testing.T) {
+		w := serve(http.MethodGet, "/style.css", gzipString)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, gzipString, w.Header().Get(contentEncoding))
+		assert.True(t, strings.HasPrefix(w.Header().Get(etagHeader), "W/"), "etag is not weakened")
+		assert.Empty(t, w.Header().Get(contentLength))
+		assert.Equal(t, string(fsys["style.css"].Data), unzip(t, w))
+	})
+	t.Run("Not compressible", func(t *testing.T) {
+		w := serve(http.MethodGet, "/logo.png", gzipString)
+		assert.Empty(t, w.Header().Get(contentEncoding))
+		assert.Equal(t, fsys["logo.png"].Data, w.Body.Bytes())
+	})
+	t.Run("Index", func(t *testing.T) {
+		w := serve(http.MethodGet, "/", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "<html></html>", w.Body.String())
+	})
+	t.Run("Errors", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/none.js", "").Code)
+		assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/../app.js/x", "").Code)
+		assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodPost, "/app.js", "").Code)
+	})
+}
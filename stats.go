@@ -0,0 +1,160 @@
+package middlewares
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CompressionStats contains compression values of one response.
+type CompressionStats struct {
+	Encoding     string        // Content coding of response, empty if response is not compressed.
+	ContentType  string        // Media type of response without parameters.
+	Uncompressed int64         // Size of data written by handler.
+	Compressed   int64         // Size of data sent to client.
+	Duration     time.Duration // Time spent in compressor, writes to client are not included.
+}
+
+// Ratio returns uncompressed to compressed size ratio.
+func (s *CompressionStats) Ratio() float64 {
+	if s.Compressed == 0 {
+		return 0
+	}
+	return float64(s.Uncompressed) / float64(s.Compressed)
+}
+
+// Saved returns number of bytes saved by compression. It is negative if compression is useless.
+func (s *CompressionStats) Saved() int64 {
+	return s.Uncompressed - s.Compressed
+}
+
+// CompressionCounter contains aggregated compression values of responses
+// with the same encoding and content type.
+type CompressionCounter struct {
+	Encoding     string
+	ContentType  string
+	Responses    int64
+	Uncompressed int64
+	Compressed   int64
+	Duration     time.Duration
+}
+
+// CompressionCounters aggregates compression values by encoding and content type.
+// Values may be exported to monitoring system by Snapshot.
+type CompressionCounters struct {
+	items map[[2]string]*CompressionCounter
+	mutex sync.Mutex
+}
+
+// NewCompressionCounters creates counters.
+func NewCompressionCounters() *CompressionCounters {
+	return &CompressionCounters{items: make(map[[2]string]*CompressionCounter)}
+}
+
+// Add adds response values to counters.
+func (c *CompressionCounters) Add(stats *CompressionStats) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := [2]string{stats.Encoding, stats.ContentType}
+	item, ok := c.items[key]
+	if !ok {
+		item = &CompressionCounter{Encoding: stats.Encoding, ContentType: stats.ContentType}
+		c.items[key] = item
+	}
+	item.Responses++
+	item.Uncompressed += stats.Uncompressed
+	item.Compressed += stats.Compressed
+	item.Duration += stats.Duration
+}
+
+// Snapshot returns copy of counters sorted by encoding and content type.
+func (c *CompressionCounters) Snapshot() []CompressionCounter {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	list := make([]CompressionCounter, 0, len(c.items))
+	for _, item := range c.items {
+		list = append(list, *item)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Encoding != list[j].Encoding {
+			return list[i].Encoding < list[j].Encoding
+		}
+		return list[i].ContentType < list[j].ContentType
+	})
+	return list
+}
+
+// WithCompressionHook sets function which is called with compression values
+// of every response. Responses which are not compressed (identity encoding, HEAD,
+// small or not compressible) have empty Encoding and Compressed equal to Uncompressed.
+func WithCompressionHook(hook func(r *http.Request, stats *CompressionStats)) CompressOption {
+	return func(c *compressConfig) {
+		c.hook = hook
+	}
+}
+
+// WithCompressionCounters sets counters for responses. Responses which are not
+// compressed are counted with empty encoding.
+func WithCompressionCounters(counters *CompressionCounters) CompressOption {
+	return func(c *compressConfig) {
+		c.counters = counters
+	}
+}
+
+// Internal types.
+type (
+	// Counts data written to writer and time of writes.
+	countWriter struct {
+		w        io.Writer
+		n        int64
+		duration time.Duration
+	}
+)
+
+// Write writes data and counts it.
+func (c *countWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := c.w.Write(p)
+	c.duration += time.Since(start)
+	c.n += int64(n)
+	return n, err //nolint:wrapcheck //<-senselessly
+}
+
+// withStats returns request with CompressionStats context value. The value set by
+// outer middleware (e.g. LoggerMiddleware) is used. Returns nil stats if nobody needs them.
+func (c *compressConfig) withStats(r *http.Request) (*http.Request, *CompressionStats) {
+	if stats, ok := r.Context().Value(CompressStats).(*CompressionStats); ok {
+		return r, stats
+	}
+	if c.hook == nil && c.counters == nil {
+		return r, nil
+	}
+	stats := &CompressionStats{}
+	return r.WithContext(context.WithValue(r.Context(), CompressStats, stats)), stats
+}
+
+// report calls hook and counters with values of response.
+func (c *compressConfig) report(r *http.Request, stats *CompressionStats) {
+	if stats == nil {
+		return
+	}
+	if c.hook != nil {
+		c.hook(r, stats)
+	}
+	if c.counters != nil {
+		c.counters.Add(stats)
+	}
+}
+
+// mediaType returns media type of Content-Type header value without parameters.
+func mediaType(value string) string {
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
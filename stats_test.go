@@ -0,0 +1,126 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestWithCompressionHook(t *testing.T) {
+	data := strings.Repeat(`{"id":"metric","value":1.5}`, 100)
+	var hookStats *CompressionStats
+	counters := NewCompressionCounters()
+	handler := NewCompressMiddleware(zap.NewNop().Sugar(),
+		WithCompressionCounters(counters),
+		WithCompressionHook(func(r *http.Request, stats *CompressionStats) {
+			hookStats = stats
+			assert.Equal(t, stats, r.Context().Value(CompressStats), "context value is not the same")
+		}),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(contentType, r.URL.Query().Get("type"))
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write([]byte(data))
+		assert.NoError(t, err)
+	}))
+	for _, target := range []string{"/?type=application/json", "/?type=application/json", "/?type=text/plain", "/?type=image/png"} {
+		hookStats = nil
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set(acceptEncoding, gzipString)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if !assert.NotNil(t, hookStats, "hook is not called") {
+			continue
+		}
+		if strings.HasSuffix(target, "png") {
+			assert.Empty(t, hookStats.Encoding, "not compressible response")
+			assert.Equal(t, int64(len(data)), hookStats.Uncompressed)
+			assert.Equal(t, hookStats.Uncompressed, hookStats.Compressed)
+			continue
+		}
+		assert.Equal(t, gzipString, hookStats.Encoding)
+		assert.Equal(t, int64(len(data)), hookStats.Uncompressed)
+		assert.Equal(t, int64(w.Body.Len()), hookStats.Compressed)
+		assert.Greater(t, hookStats.Ratio(), 1.0)
+		assert.Equal(t, hookStats.Uncompressed-hookStats.Compressed, hookStats.Saved())
+	}
+	snapshot := counters.Snapshot()
+	if assert.Len(t, snapshot, 3) {
+		assert.Equal(t, "", snapshot[0].Encoding)
+		assert.Equal(t, "image/png", snapshot[0].ContentType)
+		assert.Equal(t, applicationJSON, snapshot[1].ContentType)
+		assert.Equal(t, int64(2), snapshot[1].Responses)
+		assert.Equal(t, int64(2*len(data)), snapshot[1].Uncompressed)
+		assert.Equal(t, "text/plain", snapshot[2].ContentType)
+		assert.Equal(t, int64(1), snapshot[2].Responses)
+	}
+}
+
+func TestWithCompressionHook_uncompressed(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		accept string
+		data   string
+	}{
+		{name: "Below minimum size", method: http.MethodGet, accept: gzipString, data: "small"},
+		{name: "Identity", method: http.MethodGet, accept: "identity", data: strings.Repeat("data", 1000)},
+		{name: "Without Accept-Encoding", method: http.MethodGet, data: strings.Repeat("data", 1000)},
+		{name: "HEAD", method: http.MethodHead, accept: gzipString, data: strings.Repeat("data", 1000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hookStats *CompressionStats
+			handler := NewCompressMiddleware(zap.NewNop().Sugar(),
+				WithCompressionHook(func(r *http.Request, stats *CompressionStats) {
+					hookStats = stats
+				}),
+			)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(contentType, applicationJSON)
+				_, err := w.Write([]byte(tt.data))
+				assert.NoError(t, err)
+			}))
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.accept != "" {
+				r.Header.Set(acceptEncoding, tt.accept)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			if !assert.NotNil(t, hookStats, "hook is not called") {
+				return
+			}
+			assert.Empty(t, hookStats.Encoding)
+			assert.Equal(t, applicationJSON, hookStats.ContentType)
+			assert.Equal(t, int64(len(tt.data)), hookStats.Uncompressed)
+			assert.Equal(t, hookStats.Uncompressed, hookStats.Compressed)
+			assert.Equal(t, int64(0), hookStats.Saved())
+		})
+	}
+}
+
+func TestLoggerMiddleware_compression(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core).Sugar()
+	handler := LoggerMiddleware(logger)(GzipMiddleware(logger)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(contentType, applicationJSON)
+			w.WriteHeader(http.StatusOK)
+			_, err := w.Write([]byte(strings.Repeat("data", 1000)))
+			assert.NoError(t, err)
+		})))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(acceptEncoding, gzipString)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	entries := logs.FilterMessage("Response logger").All()
+	if assert.Len(t, entries, 1) {
+		fields := entries[0].ContextMap()
+		assert.Equal(t, gzipString, fields["encoding"])
+		assert.Equal(t, int64(4000), fields["uncompressed_size"])
+		assert.Contains(t, fields, "compression_ratio")
+		assert.Contains(t, fields, "compression_time")
+	}
+}
@@ -0,0 +1,356 @@
+package middlewares
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrStructuredField is returned when structured field value (RFC 8941) can't be parsed.
+var ErrStructuredField = errors.New("structured field parse error")
+
+// Internal types.
+type (
+	// Token bare item of structured field.
+	sfToken string
+	// Parameter of structured field item.
+	sfParam struct {
+		value any // int64, string, sfToken, []byte or bool.
+		name  string
+	}
+	// Item or inner list of structured field with parameters.
+	// Value of inner list is []sfItem.
+	sfItem struct {
+		value  any
+		params []sfParam
+	}
+	// Member of structured field dictionary.
+	sfMember struct {
+		item sfItem
+		name string
+	}
+	// Structured field parser. Decimals are not supported.
+	sfParser struct {
+		s string
+		i int
+	}
+)
+
+// parseSFDictionary parses structured field dictionary. Members order is kept,
+// duplicated member replaces the previous one.
+func parseSFDictionary(value string) ([]sfMember, error) {
+	p := &sfParser{s: value}
+	p.skipSP()
+	members := make([]sfMember, 0)
+	for !p.eof() {
+		name, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		item := sfItem{value: true}
+		if p.peek() == '=' {
+			p.i++
+			item, err = p.itemOrInnerList()
+		} else {
+			item.params, err = p.params()
+		}
+		if err != nil {
+			return nil, err
+		}
+		members = addSFMember(members, sfMember{name: name, item: item})
+		p.skipOWS()
+		if p.eof() {
+			break
+		}
+		if p.peek() != ',' {
+			return nil, p.error("',' expected")
+		}
+		p.i++
+		p.skipOWS()
+		if p.eof() {
+			return nil, p.error("trailing ','")
+		}
+	}
+	return members, nil
+}
+
+// addSFMember adds or replaces dictionary member.
+func addSFMember(members []sfMember, member sfMember) []sfMember {
+	for i := range members {
+		if members[i].name == member.name {
+			members[i] = member
+			return members
+		}
+	}
+	return append(members, member)
+}
+
+// lookupSFMember returns dictionary member by name.
+func lookupSFMember(members []sfMember, name string) (sfItem, bool) {
+	for _, item := range members {
+		if item.name == name {
+			return item.item, true
+		}
+	}
+	return sfItem{}, false
+}
+
+// error returns parse error with position.
+func (p *sfParser) error(msg string) error {
+	return fmt.Errorf("%w: %s at %d", ErrStructuredField, msg, p.i)
+}
+
+func (p *sfParser) eof() bool {
+	return p.i >= len(p.s)
+}
+
+func (p *sfParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+func (p *sfParser) skipSP() {
+	for !p.eof() && p.s[p.i] == ' ' {
+		p.i++
+	}
+}
+
+func (p *sfParser) skipOWS() {
+	for !p.eof() && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+// itemOrInnerList parses item or inner list with parameters.
+func (p *sfParser) itemOrInnerList() (sfItem, error) {
+	if p.peek() != '(' {
+		return p.item()
+	}
+	p.i++
+	list := make([]sfItem, 0)
+	for {
+		p.skipSP()
+		if p.eof() {
+			return sfItem{}, p.error("inner list is not closed")
+		}
+		if p.peek() == ')' {
+			p.i++
+			break
+		}
+		item, err := p.item()
+		if err != nil {
+			return sfItem{}, err
+		}
+		list = append(list, item)
+		if c := p.peek(); c != ' ' && c != ')' {
+			return sfItem{}, p.error("' ' or ')' expected")
+		}
+	}
+	params, err := p.params()
+	if err != nil {
+		return sfItem{}, err
+	}
+	return sfItem{value: list, params: params}, nil
+}
+
+// item parses bare item with parameters.
+func (p *sfParser) item() (sfItem, error) {
+	value, err := p.bareItem()
+	if err != nil {
+		return sfItem{}, err
+	}
+	params, err := p.params()
+	if err != nil {
+		return sfItem{}, err
+	}
+	return sfItem{value: value, params: params}, nil
+}
+
+// params parses item parameters.
+func (p *sfParser) params() ([]sfParam, error) {
+	params := make([]sfParam, 0)
+	for p.peek() == ';' {
+		p.i++
+		p.skipSP()
+		name, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		param := sfParam{name: name, value: true}
+		if p.peek() == '=' {
+			p.i++
+			if param.value, err = p.bareItem(); err != nil {
+				return nil, err
+			}
+		}
+		replaced := false
+		for i := range params {
+			if params[i].name == name {
+				params[i] = param
+				replaced = true
+			}
+		}
+		if !replaced {
+			params = append(params, param)
+		}
+	}
+	return params, nil
+}
+
+// key parses dictionary or parameter key.
+func (p *sfParser) key() (string, error) {
+	start := p.i
+	if c := p.peek(); !(c >= 'a' && c <= 'z' || c == '*') {
+		return "", p.error("key expected")
+	}
+	for !p.eof() {
+		c := p.s[p.i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("_-.*", c) >= 0) {
+			break
+		}
+		p.i++
+	}
+	return p.s[start:p.i], nil
+}
+
+// bareItem parses integer, string, token, byte sequence or boolean.
+func (p *sfParser) bareItem() (any, error) {
+	switch c := p.peek(); {
+	case c == '-' || c >= '0' && c <= '9':
+		return p.integer()
+	case c == '"':
+		return p.string()
+	case c == ':':
+		return p.bytes()
+	case c == '?':
+		if p.i+1 < len(p.s) && (p.s[p.i+1] == '0' || p.s[p.i+1] == '1') {
+			p.i += 2
+			return p.s[p.i-1] == '1', nil
+		}
+		return nil, p.error("boolean expected")
+	case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '*':
+		return p.token(), nil
+	default:
+		return nil, p.error("bare item expected")
+	}
+}
+
+func (p *sfParser) integer() (int64, error) {
+	start := p.i
+	if p.peek() == '-' {
+		p.i++
+	}
+	for !p.eof() && p.s[p.i] >= '0' && p.s[p.i] <= '9' {
+		p.i++
+	}
+	if p.peek() == '.' {
+		return 0, p.error("decimals are not supported")
+	}
+	value, err := strconv.ParseInt(p.s[start:p.i], 10, 64)
+	if err != nil || p.i-start > 16 {
+		return 0, p.error("incorrect integer")
+	}
+	return value, nil
+}
+
+func (p *sfParser) string() (string, error) {
+	p.i++
+	var b strings.Builder
+	for !p.eof() {
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case c == '\\':
+			if p.eof() || (p.s[p.i] != '"' && p.s[p.i] != '\\') {
+				return "", p.error("incorrect escape")
+			}
+			b.WriteByte(p.s[p.i])
+			p.i++
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", p.error("incorrect string character")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.error("string is not closed")
+}
+
+func (p *sfParser) bytes() ([]byte, error) {
+	p.i++
+	end := strings.IndexByte(p.s[p.i:], ':')
+	if end < 0 {
+		return nil, p.error("byte sequence is not closed")
+	}
+	data, err := base64.StdEncoding.DecodeString(p.s[p.i : p.i+end])
+	if err != nil {
+		return nil, p.error("incorrect byte sequence")
+	}
+	p.i += end + 1
+	return data, nil
+}
+
+func (p *sfParser) token() sfToken {
+	start := p.i
+	for !p.eof() {
+		c := p.s[p.i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),;<=>?@[\]{}`, c) >= 0 {
+			break
+		}
+		p.i++
+	}
+	return sfToken(p.s[start:p.i])
+}
+
+// serializeSFItem serializes item or inner list with parameters.
+func serializeSFItem(item sfItem) string {
+	var b strings.Builder
+	if list, ok := item.value.([]sfItem); ok {
+		b.WriteByte('(')
+		for i, value := range list {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(serializeSFItem(value))
+		}
+		b.WriteByte(')')
+	} else {
+		b.WriteString(serializeSFBareItem(item.value))
+	}
+	for _, param := range item.params {
+		b.WriteByte(';')
+		b.WriteString(param.name)
+		if value, ok := param.value.(bool); ok && value {
+			continue
+		}
+		b.WriteByte('=')
+		b.WriteString(serializeSFBareItem(param.value))
+	}
+	return b.String()
+}
+
+// serializeSFBareItem serializes bare item.
+func serializeSFBareItem(value any) string {
+	switch v := value.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case string:
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+	case sfToken:
+		return string(v)
+	case []byte:
+		return ":" + base64.StdEncoding.EncodeToString(v) + ":"
+	case bool:
+		if v {
+			return "?1"
+		}
+		return "?0"
+	default:
+		return fmt.Sprint(v)
+	}
+}
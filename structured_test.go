@@ -0,0 +1,47 @@
+package middlewares
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseSFDictionary(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "Inner list with params",
+			value: `sig1=("@method" "content-type");created=1618884473;keyid="k\"1"`,
+			want:  `sig1=("@method" "content-type");created=1618884473;keyid="k\"1"`,
+		},
+		{name: "Byte sequence", value: `sig1=:AQID:, sig2=:BAU=:`, want: `sig1=:AQID:, sig2=:BAU=:`},
+		{name: "Tokens and booleans", value: `a=sha-256;x, b=?0,c`, want: `a=sha-256;x, b=?0, c=?1`},
+		{name: "Duplicated member", value: `a=1, a=2`, want: `a=2`},
+		{name: "Trailing comma", value: `a=1,`, wantErr: true},
+		{name: "Decimal", value: `a=1.5`, wantErr: true},
+		{name: "Not closed list", value: `a=("x"`, wantErr: true},
+		{name: "Incorrect key", value: `A=1`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			members, err := parseSFDictionary(tt.value)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrStructuredField)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			list := make([]string, 0, len(members))
+			for _, item := range members {
+				list = append(list, item.name+"="+serializeSFItem(item.item))
+			}
+			assert.Equal(t, tt.want, strings.Join(list, ", "))
+		})
+	}
+}
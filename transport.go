@@ -0,0 +1,294 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// HashResponseError is returned by transport of NewHashTransport when response
+// hash summ is missing, malformed or not equal to body HMAC.
+type HashResponseError struct {
+	Err        error // ErrHashMissing, ErrHashMalformed or ErrHashMismatch.
+	StatusCode int   // Status of response.
+}
+
+// Error returns error message.
+func (e *HashResponseError) Error() string {
+	return fmt.Sprintf("response hash summ error, status %d: %v", e.StatusCode, e.Err)
+}
+
+// Unwrap returns hash summ error.
+func (e *HashResponseError) Unwrap() error {
+	return e.Err
+}
+
+// Internal types.
+type (
+	// Client side compression.
+	compressTransport struct {
+		base http.RoundTripper
+		cfg  *compressConfig
+	}
+	// Client side hash summ.
+	hashTransport struct {
+		base http.RoundTripper
+		cfg  *hashConfig
+		key  []byte
+	}
+)
+
+// NewCompressTransport creates http.RoundTripper which compresses request bodies and
+// decompresses responses of servers which use NewCompressMiddleware or GzipMiddleware.
+//
+// Request body is compressed by the first encoder of WithEncoders option when its size
+// reaches WithMinSize. Requests which already have Content-Encoding are sent as is.
+// Accept-Encoding header lists encoders of WithEncoders option, unless the request sets it,
+// and responses are decompressed with WithMaxDecompressedSize and WithMaxRatio limits.
+// If base is nil, http.DefaultTransport is used.
+//
+// HashCheckMiddleware on server checks hash summ of decompressed body, so request signing
+// must be done before compression: signing transport must wrap the compression transport,
+// and the hash summ of response is checked after decompression too.
+func NewCompressTransport(base http.RoundTripper, opts ...CompressOption) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &compressTransport{base: base, cfg: newCompressConfig(opts...)}
+}
+
+// RoundTrip compresses request and decompresses response.
+func (t *compressTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	req := r.Clone(r.Context())
+	if err := t.compressRequest(req); err != nil {
+		if r.Body != nil {
+			r.Body.Close() //nolint:errcheck //<-RoundTripper must close body
+		}
+		return nil, err
+	}
+	decompress := false
+	if req.Header.Get(acceptEncoding) == "" && len(t.cfg.encoders) > 0 {
+		req.Header.Set(acceptEncoding, strings.Join(t.cfg.encoders, ", "))
+		decompress = true
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, fmt.Errorf("compress transport round trip error: %w", err)
+	}
+	if !decompress || resp.Header.Get(contentEncoding) == "" || req.Method == http.MethodHead {
+		return resp, nil
+	}
+	body, err := newBodyDecompressor(resp.Body, resp.Header.Get(contentEncoding), t.cfg)
+	if err != nil {
+		resp.Body.Close() //nolint:errcheck //<-response is not returned
+		return nil, fmt.Errorf("response decompress error: %w", err)
+	}
+	resp.Body = body
+	resp.Header.Del(contentEncoding)
+	resp.Header.Del(contentLength)
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// compressRequest replaces request body by compressed one.
+func (t *compressTransport) compressRequest(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody || req.Header.Get(contentEncoding) != "" ||
+		len(t.cfg.encoders) == 0 {
+		return nil
+	}
+	if req.ContentLength > 0 && req.ContentLength < int64(t.cfg.minSize) {
+		return nil
+	}
+	encoder, ok := lookupEncoder(t.cfg.encoders[0])
+	if !ok {
+		return fmt.Errorf("encoder '%s' is not registered", t.cfg.encoders[0])
+	}
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		return getError(ReadBodyError, err)
+	}
+	if err = req.Body.Close(); err != nil {
+		return getError(CloseBodyError, err)
+	}
+	if len(data) < t.cfg.minSize {
+		setRequestBody(req, data)
+		return nil
+	}
+	var buf bytes.Buffer
+	compressor, err := encoder.get(&buf)
+	if err != nil {
+		return err
+	}
+	defer encoder.put(compressor)
+	if _, err = compressor.Write(data); err != nil {
+		return fmt.Errorf("compress request body error: %w", err)
+	}
+	if err = compressor.Close(); err != nil {
+		return fmt.Errorf("compress request body close error: %w", err)
+	}
+	setRequestBody(req, buf.Bytes())
+	req.Header.Set(contentEncoding, encoder.Encoding())
+	return nil
+}
+
+// setRequestBody sets request body which may be read several times for redirects and retries.
+func setRequestBody(req *http.Request, data []byte) {
+	req.ContentLength = int64(len(data))
+	req.Body = &bodyReader{Reader: bytes.NewReader(data), data: data}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+// NewHashTransport creates http.RoundTripper which sets hash summ of request bodies as
+// NewHashCheckMiddleware expects and verifies hash summ of responses, sent in header or trailer.
+// Requests with methods checked by the middleware are signed: POST, and PUT, PATCH, DELETE
+// with WithStrictHash option. Options:
+//   - WithHashAlgorithms: the first algorithm is used;
+//   - WithHashKeys and WithHashKeyID: key is selected by key ID, key argument is not used;
+//   - WithHashReplayGuard: replay protection values are sent, the guard is not used by client;
+//   - WithHashCanonicalJSON: hash summ of JSON request body is calculated for its canonical form;
+//   - WithHashStreaming: response body is not buffered, and verification error is returned
+//     by Body.Read at EOF instead of RoundTrip.
+//
+// Responses with missing or incorrect hash summ are returned as *HashResponseError, including
+// responses with empty body and error responses of the middleware. Responses to HEAD
+// requests, 204 and 304 responses have no body, so their hash summ is not checked.
+// The transport must wrap NewCompressTransport, because hash summ is calculated for
+// uncompressed data. If base is nil, http.DefaultTransport is used.
+func NewHashTransport(base http.RoundTripper, key []byte, opts ...HashOption) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	cfg := &hashConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return &hashTransport{base: base, cfg: cfg, key: key}
+}
+
+// RoundTrip signs request and verifies response.
+func (t *hashTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !t.cfg.covers(r.Method) {
+		return t.base.RoundTrip(r) //nolint:wrapcheck //<-senselessly
+	}
+	req := r.Clone(r.Context())
+	key, err := t.signRequest(req)
+	if err != nil {
+		if r.Body != nil {
+			r.Body.Close() //nolint:errcheck //<-RoundTripper must close body
+		}
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, fmt.Errorf("hash transport round trip error: %w", err)
+	}
+	if !responseSigned(req.Method, resp.StatusCode) {
+		return resp, nil
+	}
+	alg := t.cfg.responseAlgorithm(req.Header.Get(hashVarName))
+	if hash := resp.Header.Get(hashVarName); hash != "" {
+		if parsed, _, err := parseHash(hash); err == nil {
+			alg = parsed
+		}
+	}
+	vr := &verifyingReader{
+		body: resp.Body,
+		mac:  hmac.New(hashAlgorithm(alg), key),
+		verify: func(_ int64, mac []byte) error {
+			hash := resp.Header.Get(hashVarName)
+			if hash == "" {
+				hash = resp.Trailer.Get(hashVarName)
+			}
+			if err := checkResponseHash(hash, mac); err != nil {
+				return &HashResponseError{Err: err, StatusCode: resp.StatusCode}
+			}
+			return nil
+		},
+	}
+	if t.cfg.stream {
+		resp.Body = vr
+		return resp, nil
+	}
+	data, err := io.ReadAll(vr)
+	resp.Body.Close() //nolint:errcheck //<-body is read
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	return resp, nil
+}
+
+// signRequest sets hash summ, key ID and replay protection values of request.
+// Returns key for response verification.
+func (t *hashTransport) signRequest(req *http.Request) ([]byte, error) {
+	key := t.key
+	if t.cfg.keys != nil {
+		var err error
+		if key, err = t.cfg.keys(t.cfg.keyID); err != nil {
+			return nil, fmt.Errorf("hash key '%s' error: %w", t.cfg.keyID, err)
+		}
+	}
+	if t.cfg.keyID != "" {
+		req.Header.Set(hashKeyIDName, t.cfg.keyID)
+	}
+	var data []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if data, err = io.ReadAll(req.Body); err != nil {
+			return nil, getError(ReadBodyError, err)
+		}
+		if err = req.Body.Close(); err != nil {
+			return nil, getError(CloseBodyError, err)
+		}
+		setRequestBody(req, data)
+	}
+	signed := data
+	if t.cfg.canonical && len(data) > 0 && isJSONRequest(req) {
+		var err error
+		if signed, err = CanonicalJSON(data); err != nil {
+			return nil, err
+		}
+	}
+	var prefix []byte
+	if t.cfg.replay != nil {
+		params, err := NewReplayParams()
+		if err != nil {
+			return nil, err
+		}
+		params.SetHeaders(req.Header)
+		prefix = params.bytes()
+	}
+	alg := t.cfg.responseAlgorithm("")
+	mac := hmac.New(hashAlgorithm(alg), key)
+	mac.Write(prefix) //nolint:errcheck //<-hash.Hash never returns error
+	mac.Write(signed) //nolint:errcheck //<-hash.Hash never returns error
+	req.Header.Set(hashVarName, formatHash(alg, mac.Sum(nil)))
+	return key, nil
+}
+
+// responseSigned checks that response to signed request must have hash summ.
+// Responses to HEAD requests, 204 and 304 responses have no body.
+func responseSigned(method string, statusCode int) bool {
+	return method != http.MethodHead &&
+		statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+}
+
+// checkResponseHash checks response hash summ by HMAC calculated for body.
+// Hash summ is required for responses without body too.
+func checkResponseHash(hash string, mac []byte) error {
+	if hash == "" {
+		return ErrHashMissing
+	}
+	_, sum, err := parseHash(hash)
+	if err != nil {
+		return err
+	}
+	return compareHash(sum, mac, hash)
+}
@@ -0,0 +1,247 @@
+package middlewares
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gostuding/middlewares/keys"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestNewCompressTransport(t *testing.T) {
+	var encoding string
+	server := httptest.NewServer(NewCompressMiddleware(zap.NewNop().Sugar())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding = r.Header.Get(contentEncoding)
+			data, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(readErrorStatus(err))
+				return
+			}
+			w.Header().Set(contentType, applicationJSON)
+			w.WriteHeader(http.StatusOK)
+			_, err = w.Write(bytes.Repeat(data, 10))
+			assert.NoError(t, err)
+		})))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		body     string
+		encoding string
+		opts     []CompressOption
+		status   int
+	}{
+		{name: "Large body", body: strings.Repeat(`{"value":1}`, 200), encoding: gzipString, status: http.StatusOK},
+		{name: "Small body", body: `{"value":1}`, encoding: "", status: http.StatusOK},
+		{
+			name:     "Deflate",
+			body:     strings.Repeat(`{"value":1}`, 200),
+			encoding: deflateString,
+			opts:     []CompressOption{WithEncoders(deflateString)},
+			status:   http.StatusOK,
+		},
+		{
+			name:     "Response limit",
+			body:     strings.Repeat(`{"value":1}`, 200),
+			encoding: gzipString,
+			opts:     []CompressOption{WithMaxDecompressedSize(1024)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := http.Client{Transport: NewCompressTransport(nil, tt.opts...)}
+			req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(tt.body))
+			assert.NoError(t, err)
+			resp, err := client.Do(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close() //nolint:errcheck //<-test
+			assert.Equal(t, tt.encoding, encoding, "request encoding")
+			data, err := io.ReadAll(resp.Body)
+			if tt.status == 0 {
+				var limitErr *DecompressLimitError
+				assert.ErrorAs(t, err, &limitErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, len(data) >= defaultMinSize, resp.Uncompressed, "response decompression")
+			assert.Empty(t, resp.Header.Get(contentEncoding))
+			assert.Equal(t, strings.Repeat(tt.body, 10), string(data))
+		})
+	}
+}
+
+func TestNewCompressTransport_preset(t *testing.T) {
+	server := httptest.NewServer(GzipMiddleware(zap.NewNop().Sugar())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(contentType, applicationJSON)
+			w.WriteHeader(http.StatusOK)
+			_, err := w.Write([]byte(strings.Repeat("data", 1000)))
+			assert.NoError(t, err)
+		})))
+	defer server.Close()
+	client := http.Client{Transport: NewCompressTransport(nil)}
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	assert.NoError(t, err)
+	req.Header.Set(acceptEncoding, gzipString)
+	resp, err := client.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close() //nolint:errcheck //<-test
+	assert.Equal(t, gzipString, resp.Header.Get(contentEncoding), "response with preset Accept-Encoding decoded")
+	assert.Equal(t, gzipString, req.Header.Get(acceptEncoding), "request headers changed")
+}
+
+func TestNewHashTransport(t *testing.T) {
+	key := []byte("key")
+	ring := keys.NewRing[[]byte]()
+	ring.Add("k1", []byte("old key"))
+	ring.Add("k2", key)
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(contentType, applicationJSON)
+		w.WriteHeader(http.StatusOK)
+		_, err := io.Copy(w, r.Body)
+		assert.NoError(t, err)
+	})
+	tests := []struct {
+		name   string
+		server []HashOption
+		client []HashOption
+	}{
+		{name: "Header"},
+		{name: "Trailer", server: []HashOption{WithHashTrailer()}},
+		{
+			name:   "SHA512",
+			server: []HashOption{WithHashAlgorithms(HashAlgSHA512), WithStrictHash()},
+			client: []HashOption{WithHashAlgorithms(HashAlgSHA512)},
+		},
+		{
+			name:   "Key ID",
+			server: []HashOption{WithHashKeys(ring.Lookup), WithStrictHash()},
+			client: []HashOption{WithHashKeys(ring.Lookup), WithHashKeyID("k2")},
+		},
+		{
+			name:   "Replay",
+			server: []HashOption{WithHashReplayGuard(NewReplayGuard(time.Minute, nil))},
+			client: []HashOption{WithHashReplayGuard(NewReplayGuard(time.Minute, nil))},
+		},
+		{name: "Streaming", server: []HashOption{WithHashTrailer()}, client: []HashOption{WithHashStreaming()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(NewHashCheckMiddleware(key, zap.NewNop().Sugar(), tt.server...)(echo))
+			defer server.Close()
+			client := http.Client{Transport: NewHashTransport(nil, key, tt.client...)}
+			resp, err := client.Post(server.URL, applicationJSON, strings.NewReader(`{"value":1}`))
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close() //nolint:errcheck //<-test
+			data, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, `{"value":1}`, string(data))
+		})
+	}
+}
+
+func TestNewHashTransport_tampered(t *testing.T) {
+	key := []byte("key")
+	tests := []struct {
+		name   string
+		hash   string
+		stream bool
+		err    error
+	}{
+		{name: "Mismatch", hash: strings.Repeat("00", 32), err: ErrHashMismatch},
+		{name: "Missing", err: ErrHashMissing},
+		{name: "Malformed", hash: "zz", err: ErrHashMalformed},
+		{name: "Streaming", hash: strings.Repeat("00", 32), stream: true, err: ErrHashMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, "data", string(body))
+				cfg := hashConfig{strict: true}
+				assert.NoError(t, cfg.check(r, body, key), "request hash summ")
+				if tt.hash != "" {
+					w.Header().Set(hashVarName, tt.hash)
+				}
+				w.WriteHeader(http.StatusCreated)
+				_, err = w.Write([]byte("tampered"))
+				assert.NoError(t, err)
+			}))
+			defer server.Close()
+			var opts []HashOption
+			if tt.stream {
+				opts = append(opts, WithHashStreaming())
+			}
+			client := http.Client{Transport: NewHashTransport(nil, key, opts...)}
+			resp, err := client.Post(server.URL, "text/plain", strings.NewReader("data"))
+			if tt.stream {
+				if !assert.NoError(t, err) {
+					return
+				}
+				defer resp.Body.Close() //nolint:errcheck //<-test
+				_, err = io.ReadAll(resp.Body)
+			}
+			var hashErr *HashResponseError
+			if assert.ErrorAs(t, err, &hashErr) {
+				assert.Equal(t, http.StatusCreated, hashErr.StatusCode)
+			}
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestNewHashTransport_emptyResponse(t *testing.T) {
+	key := []byte("key")
+	tests := []struct {
+		err    error
+		name   string
+		status int
+		signed bool
+	}{
+		{name: "Unsigned empty", status: http.StatusOK, err: ErrHashMissing},
+		{name: "Signed empty", status: http.StatusOK, signed: true},
+		{name: "Signed empty created", status: http.StatusCreated, signed: true},
+		{name: "No content", status: http.StatusNoContent},
+		{name: "Not modified", status: http.StatusNotModified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.status != http.StatusOK {
+					w.WriteHeader(tt.status)
+				}
+			})
+			if tt.signed {
+				handler = NewHashCheckMiddleware(key, zap.NewNop().Sugar())(handler)
+			}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+			client := http.Client{Transport: NewHashTransport(nil, key)}
+			resp, err := client.Post(server.URL, "text/plain", strings.NewReader("data"))
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			if assert.NoError(t, err) {
+				resp.Body.Close() //nolint:errcheck //<-test
+				assert.Equal(t, tt.status, resp.StatusCode)
+			}
+		})
+	}
+}
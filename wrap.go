@@ -0,0 +1,200 @@
+package middlewares
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// Internal types.
+type (
+	// Response writer wrapped by middleware.
+	rwWrapper interface {
+		http.ResponseWriter
+		// Unwrap returns the underlying writer. It is used by http.ResponseController too.
+		Unwrap() http.ResponseWriter
+	}
+	// Optional methods of wrapped writer. A method of wrapper is called
+	// if wrapper implements it, otherwise the method of the underlying writer is called.
+	writerMethods struct {
+		w rwWrapper
+	}
+	// Hides optional methods of writer.
+	writerOnly struct {
+		io.Writer
+	}
+)
+
+// Optional interfaces of response writer.
+const (
+	flusherBit = 1 << iota
+	hijackerBit
+	readerFromBit
+	pusherBit
+)
+
+// wrapWriter returns writer with methods of wrapper which implements exactly the
+// optional interfaces of the underlying writer: http.Flusher, http.Hijacker,
+// io.ReaderFrom and http.Pusher. So wrappers may be stacked without breaking
+// streaming, connection hijacking and sendfile.
+func wrapWriter(w rwWrapper) http.ResponseWriter {
+	var kind int
+	base := w.Unwrap()
+	if _, ok := base.(http.Flusher); ok {
+		kind |= flusherBit
+	}
+	if _, ok := base.(http.Hijacker); ok {
+		kind |= hijackerBit
+	}
+	if _, ok := base.(io.ReaderFrom); ok {
+		kind |= readerFromBit
+	}
+	if _, ok := base.(http.Pusher); ok {
+		kind |= pusherBit
+	}
+	m := writerMethods{w: w}
+	switch kind {
+	case 0:
+		return struct {
+			rwWrapper
+		}{w}
+	case flusherBit:
+		return struct {
+			rwWrapper
+			http.Flusher
+		}{w, m}
+	case hijackerBit:
+		return struct {
+			rwWrapper
+			http.Hijacker
+		}{w, m}
+	case flusherBit | hijackerBit:
+		return struct {
+			rwWrapper
+			http.Flusher
+			http.Hijacker
+		}{w, m, m}
+	case readerFromBit:
+		return struct {
+			rwWrapper
+			io.ReaderFrom
+		}{w, m}
+	case flusherBit | readerFromBit:
+		return struct {
+			rwWrapper
+			http.Flusher
+			io.ReaderFrom
+		}{w, m, m}
+	case hijackerBit | readerFromBit:
+		return struct {
+			rwWrapper
+			http.Hijacker
+			io.ReaderFrom
+		}{w, m, m}
+	case flusherBit | hijackerBit | readerFromBit:
+		return struct {
+			rwWrapper
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{w, m, m, m}
+	case pusherBit:
+		return struct {
+			rwWrapper
+			http.Pusher
+		}{w, m}
+	case flusherBit | pusherBit:
+		return struct {
+			rwWrapper
+			http.Flusher
+			http.Pusher
+		}{w, m, m}
+	case hijackerBit | pusherBit:
+		return struct {
+			rwWrapper
+			http.Hijacker
+			http.Pusher
+		}{w, m, m}
+	case flusherBit | hijackerBit | pusherBit:
+		return struct {
+			rwWrapper
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{w, m, m, m}
+	case readerFromBit | pusherBit:
+		return struct {
+			rwWrapper
+			io.ReaderFrom
+			http.Pusher
+		}{w, m, m}
+	case flusherBit | readerFromBit | pusherBit:
+		return struct {
+			rwWrapper
+			http.Flusher
+			io.ReaderFrom
+			http.Pusher
+		}{w, m, m, m}
+	case hijackerBit | readerFromBit | pusherBit:
+		return struct {
+			rwWrapper
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{w, m, m, m}
+	case flusherBit | hijackerBit | readerFromBit | pusherBit:
+		return struct {
+			rwWrapper
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{w, m, m, m, m}
+	default:
+		return w
+	}
+}
+
+// Flush sends buffered data to client.
+func (m writerMethods) Flush() {
+	if f, ok := m.w.(http.Flusher); ok {
+		f.Flush()
+		return
+	}
+	if f, ok := m.w.Unwrap().(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection.
+func (m writerMethods) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := m.w.(http.Hijacker); ok {
+		return h.Hijack() //nolint:wrapcheck //<-senselessly
+	}
+	if h, ok := m.w.Unwrap().(http.Hijacker); ok {
+		return h.Hijack() //nolint:wrapcheck //<-senselessly
+	}
+	return nil, nil, fmt.Errorf("hijack error: %w", http.ErrNotSupported)
+}
+
+// ReadFrom writes data from src. If wrapper does not implement io.ReaderFrom,
+// data is written by wrapper Write method, so it can't bypass the wrapper.
+func (m writerMethods) ReadFrom(src io.Reader) (int64, error) {
+	if r, ok := m.w.(io.ReaderFrom); ok {
+		return r.ReadFrom(src) //nolint:wrapcheck //<-senselessly
+	}
+	return io.Copy(writerOnly{m.w}, src) //nolint:wrapcheck //<-senselessly
+}
+
+// Push initiates HTTP/2 server push.
+func (m writerMethods) Push(target string, opts *http.PushOptions) error {
+	if p, ok := m.w.(http.Pusher); ok {
+		return p.Push(target, opts) //nolint:wrapcheck //<-senselessly
+	}
+	if p, ok := m.w.Unwrap().(http.Pusher); ok {
+		return p.Push(target, opts) //nolint:wrapcheck //<-senselessly
+	}
+	return fmt.Errorf("push error: %w", http.ErrNotSupported)
+}
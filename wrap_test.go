@@ -0,0 +1,215 @@
+package middlewares
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// Response writer with all optional interfaces.
+type fullWriter struct {
+	*httptest.ResponseRecorder
+	flushed  bool
+	hijacked bool
+	readFrom bool
+	pushed   bool
+}
+
+func (w *fullWriter) Flush() {
+	w.flushed = true
+	w.ResponseRecorder.Flush()
+}
+
+func (w *fullWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	return nil, nil, nil
+}
+
+func (w *fullWriter) ReadFrom(src io.Reader) (int64, error) {
+	w.readFrom = true
+	return io.Copy(w.ResponseRecorder, src) //nolint:wrapcheck //<-test
+}
+
+func (w *fullWriter) Push(target string, opts *http.PushOptions) error {
+	w.pushed = true
+	return nil
+}
+
+// writerCombinations returns writers with every combination of optional interfaces of w.
+func writerCombinations(w *fullWriter) []http.ResponseWriter {
+	return []http.ResponseWriter{
+		struct {
+			http.ResponseWriter
+		}{w},
+		struct {
+			http.ResponseWriter
+			http.Flusher
+		}{w, w},
+		struct {
+			http.ResponseWriter
+			http.Hijacker
+		}{w, w},
+		struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+		}{w, w, w},
+		struct {
+			http.ResponseWriter
+			io.ReaderFrom
+		}{w, w},
+		struct {
+			http.ResponseWriter
+			http.Flusher
+			io.ReaderFrom
+		}{w, w, w},
+		struct {
+			http.ResponseWriter
+			http.Hijacker
+			io.ReaderFrom
+		}{w, w, w},
+		struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{w, w, w, w},
+		struct {
+			http.ResponseWriter
+			http.Pusher
+		}{w, w},
+		struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Pusher
+		}{w, w, w},
+		struct {
+			http.ResponseWriter
+			http.Hijacker
+			http.Pusher
+		}{w, w, w},
+		struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{w, w, w, w},
+		struct {
+			http.ResponseWriter
+			io.ReaderFrom
+			http.Pusher
+		}{w, w, w},
+		struct {
+			http.ResponseWriter
+			http.Flusher
+			io.ReaderFrom
+			http.Pusher
+		}{w, w, w, w},
+		struct {
+			http.ResponseWriter
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{w, w, w, w},
+		struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{w, w, w, w, w},
+	}
+}
+
+func Test_wrapWriter(t *testing.T) {
+	wrappers := map[string]func(w http.ResponseWriter) rwWrapper{
+		"log":  func(w http.ResponseWriter) rwWrapper { return NewLogWriter(w) },
+		"hash": func(w http.ResponseWriter) rwWrapper { return newHashWriter(w, []byte("key"), true) },
+		"gzip": func(w http.ResponseWriter) rwWrapper { return NewStreamingGzipWriter(w, zap.NewNop().Sugar()) },
+	}
+	for name, wrap := range wrappers {
+		for kind := 0; kind < 16; kind++ {
+			full := &fullWriter{ResponseRecorder: httptest.NewRecorder()}
+			base := writerCombinations(full)[kind]
+			w := wrapWriter(wrap(base))
+
+			flusher, ok := w.(http.Flusher)
+			assert.Equal(t, kind&flusherBit != 0, ok, "%s %d: http.Flusher", name, kind)
+			if ok {
+				flusher.Flush()
+				assert.True(t, full.flushed, "%s %d: flush not passed", name, kind)
+			}
+			hijacker, ok := w.(http.Hijacker)
+			assert.Equal(t, kind&hijackerBit != 0, ok, "%s %d: http.Hijacker", name, kind)
+			if ok {
+				_, _, err := hijacker.Hijack()
+				assert.NoError(t, err)
+				assert.True(t, full.hijacked, "%s %d: hijack not passed", name, kind)
+			}
+			_, ok = w.(io.ReaderFrom)
+			assert.Equal(t, kind&readerFromBit != 0, ok, "%s %d: io.ReaderFrom", name, kind)
+			pusher, ok := w.(http.Pusher)
+			assert.Equal(t, kind&pusherBit != 0, ok, "%s %d: http.Pusher", name, kind)
+			if ok {
+				assert.NoError(t, pusher.Push("/style.css", nil))
+				assert.True(t, full.pushed, "%s %d: push not passed", name, kind)
+			}
+
+			err := http.NewResponseController(w).Flush()
+			if kind&flusherBit != 0 {
+				assert.NoError(t, err, "%s %d: controller flush error", name, kind)
+			} else {
+				assert.ErrorIs(t, err, http.ErrNotSupported, "%s %d: controller flush", name, kind)
+			}
+		}
+	}
+}
+
+func Test_wrapWriter_readFrom(t *testing.T) {
+	data := strings.Repeat("data", 1000)
+	full := &fullWriter{ResponseRecorder: httptest.NewRecorder()}
+	gw := NewStreamingGzipWriter(full, zap.NewNop().Sugar())
+	gw.Header().Set(contentType, applicationJSON)
+	size, err := wrapWriter(gw).(io.ReaderFrom).ReadFrom(strings.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), size)
+	assert.NoError(t, gw.Close())
+	assert.False(t, full.readFrom, "data bypassed compressor")
+	reader, err := gzip.NewReader(bytes.NewReader(full.Body.Bytes()))
+	if assert.NoError(t, err) {
+		body, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, data, string(body))
+	}
+
+	full = &fullWriter{ResponseRecorder: httptest.NewRecorder()}
+	lw := NewLogWriter(full)
+	_, err = wrapWriter(lw).(io.ReaderFrom).ReadFrom(strings.NewReader(data))
+	assert.NoError(t, err)
+	assert.True(t, full.readFrom, "sendfile of underlying writer is not used")
+	assert.Equal(t, len(data), lw.Size)
+}
+
+func Test_wrapWriter_stacked(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	handler := LoggerMiddleware(logger)(GzipMiddleware(logger)(HashCheckMiddleware([]byte("key"), logger)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, ok := w.(http.Flusher)
+			assert.True(t, ok, "http.Flusher hidden by wrappers")
+			_, ok = w.(http.Hijacker)
+			assert.False(t, ok, "http.Hijacker is not supported by recorder")
+			assert.NoError(t, http.NewResponseController(w).Flush())
+		}))))
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+	r.Header.Set(acceptEncoding, gzipString)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+}